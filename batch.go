@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// How many spells in a batch are parsed, or have their revisions recorded, at
+// once
+const maxBatchWorkers = 8
+
+// BatchRequest is the body of POST /spells:batch, the same shape as a
+// multipost request
+type BatchRequest struct {
+	Data []json.RawMessage `json:"data"`
+}
+
+// BatchItem is what happened to one spell in a batch. Err is nil if the
+// spell was added.
+type BatchItem struct {
+	Index int
+	Name  string
+	Spell Spell
+	Err   error
+}
+
+// BatchResult is one spell in the response to POST /spells:batch
+type BatchResult struct {
+	Index  int      `json:"index"`
+	Name   string   `json:"name,omitempty"`
+	Status int      `json:"status"`
+	ID     string   `json:"id,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// BatchResponse is the response to POST /spells:batch, with a result for
+// every spell in the order they were sent
+type BatchResponse struct {
+	Count   int           `json:"count"`
+	Created int           `json:"created"`
+	Failed  int           `json:"failed"`
+	Atomic  bool          `json:"atomic"`
+	Results []BatchResult `json:"results"`
+}
+
+// AddSpellBatch adds each spell in a batch and reports what happened to every
+// one of them. Without atomic each spell is added or fails on its own. With
+// atomic every spell is checked before any are written, and they're written
+// together so either all of them are added or none are. Spells that weren't
+// added because another failed get ErrBatchAborted. The error is only set
+// when the batch couldn't be checked against the DB, or it's atomic and
+// couldn't be written, which is ErrTransactionsUnsupported if the DB can't
+// write it all at once.
+//
+// Every spell is checked for conflicts with a single query and written with
+// a single AddSpells call, so a large import doesn't cost a round trip per
+// spell.
+func AddSpellBatch(ctx context.Context, db Store, data []json.RawMessage, atomic bool, strict bool, user string) ([]BatchItem, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "AddSpellBatch")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Int("AddSpellBatch.Count", len(data)),
+		attribute.Bool("AddSpellBatch.Atomic", atomic),
+		attribute.Bool("AddSpellBatch.Strict", strict),
+	)
+
+	if atomic && !db.SupportsTransactions() {
+		span.SetAttributes(attribute.String("AddSpellBatch.Error", ErrTransactionsUnsupported.Error()))
+		return nil, ErrTransactionsUnsupported
+	}
+
+	// Parsing can look up the spell's system, so do a few at once
+	items := make([]BatchItem, len(data))
+	forEachLimited(len(data), maxBatchWorkers, func(i int) {
+		items[i] = parseBatchItem(ctx, db, i, data[i], strict)
+	})
+
+	err := checkBatchNameConflicts(ctx, db, items)
+	if err != nil {
+		span.SetAttributes(attribute.String("AddSpellBatch.Error", err.Error()))
+		return items, err
+	}
+
+	if atomic && abortBatch(items) {
+		span.SetAttributes(attribute.String("AddSpellBatch.Error", "InvalidSpells"))
+		return items, nil
+	}
+
+	for i := range items {
+		if items[i].Err == nil {
+			stampNewSpell(&items[i].Spell, user)
+		}
+	}
+
+	written, err := insertBatchItems(ctx, db, items, atomic)
+	if err != nil {
+		span.SetAttributes(attribute.String("AddSpellBatch.Error", err.Error()))
+		return items, err
+	}
+
+	span.SetAttributes(attribute.Int("AddSpellBatch.Written", len(written)))
+
+	// Nothing is recorded until the spells are in
+	forEachLimited(len(written), maxBatchWorkers, func(n int) {
+		err := recordRevision(ctx, db, RevisionCreated, items[written[n]].Spell, user)
+		warnRevisionError(span, "AddSpellBatch", err)
+	})
+
+	return items, nil
+}
+
+// checkBatchNameConflicts is checkNameConflicts for every spell in a batch
+// that hasn't already failed, looking up all their names and aliases in one
+// query. A spell that clashes with one earlier in the batch fails as well.
+func checkBatchNameConflicts(ctx context.Context, db Store, items []BatchItem) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "CheckBatchNameConflicts")
+	defer span.End()
+
+	systems := []string{}
+	keys := []string{}
+	for i := range items {
+		if items[i].Err != nil {
+			continue
+		}
+
+		items[i].Spell.setKeys()
+		items[i].Spell.ID = primitive.NilObjectID
+
+		if err := checkDuplicateAliases(items[i].Spell); err != nil {
+			items[i].Err = err
+			continue
+		}
+
+		spellKeys, _ := items[i].Spell.nameKeys()
+		systems = append(systems, items[i].Spell.Metadata.System)
+		keys = append(keys, spellKeys...)
+	}
+
+	span.SetAttributes(attribute.Int("CheckBatchNameConflicts.Keys", len(keys)))
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	bsonQuery := bson.M{
+		"metadata.system": bson.M{
+			"$in": systems,
+		},
+		"$or": []bson.M{
+			{"name": bson.M{"$in": keys}},
+			{"aliasKeys": bson.M{"$in": keys}},
+		},
+	}
+
+	results, err := db.GetSpell(ctx, bsonQuery)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckBatchNameConflicts.Error", err.Error()))
+		return fmt.Errorf("failed to check for existing spells: %w", err)
+	}
+
+	others, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckBatchNameConflicts.Error", err.Error()))
+		return err
+	}
+
+	// Which spell each name or alias in a system belongs to, starting with
+	// the ones already saved
+	existing := map[string]Spell{}
+	for _, other := range others {
+		otherKeys, _ := other.nameKeys()
+		for _, k := range otherKeys {
+			existing[other.Metadata.System+"\x00"+k] = other
+		}
+	}
+
+	claimed := map[string]int{}
+	for i := range items {
+		if items[i].Err != nil {
+			continue
+		}
+
+		spell := items[i].Spell
+		spellKeys, names := spell.nameKeys()
+		for n, k := range spellKeys {
+			claim := spell.Metadata.System + "\x00" + k
+			if other, ok := existing[claim]; ok {
+				if n == 0 && other.Key == k {
+					items[i].Err = ErrConflict
+				} else {
+					items[i].Err = fmt.Errorf("%w: %q is already used by %q", ErrConflict, names[n], other.Name)
+				}
+				break
+			}
+			if other, ok := claimed[claim]; ok {
+				items[i].Err = fmt.Errorf("%w: %q is also used by spell %d in the batch", ErrConflict, names[n], other)
+				break
+			}
+		}
+		if items[i].Err != nil {
+			continue
+		}
+
+		for _, k := range spellKeys {
+			claimed[spell.Metadata.System+"\x00"+k] = i
+		}
+	}
+
+	return nil
+}
+
+// insertBatchItems writes every spell in a batch that hasn't failed with one
+// AddSpells call, returning the index of each one that was written. When it's
+// atomic they're written with AddSpellsAtomic instead, and if any fail the
+// rest are marked as aborted and nothing is returned.
+func insertBatchItems(ctx context.Context, db Store, items []BatchItem, atomic bool) ([]int, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "InsertBatchItems")
+	defer span.End()
+
+	pending := []int{}
+	spells := [][]byte{}
+	for i := range items {
+		if items[i].Err != nil {
+			continue
+		}
+
+		bsonSpell, err := bson.Marshal(items[i].Spell)
+		if err != nil {
+			items[i].Err = fmt.Errorf("failed to marshall data: %w", err)
+			continue
+		}
+
+		pending = append(pending, i)
+		spells = append(spells, bsonSpell)
+	}
+
+	if len(spells) == 0 || (atomic && abortBatch(items)) {
+		return nil, nil
+	}
+
+	var errs []error
+	if atomic {
+		var err error
+		errs, err = db.AddSpellsAtomic(ctx, spells)
+		if err != nil {
+			span.SetAttributes(attribute.String("InsertBatchItems.Error", err.Error()))
+			return nil, err
+		}
+	} else {
+		errs = db.AddSpells(ctx, spells)
+	}
+
+	written := []int{}
+	for n, err := range errs {
+		i := pending[n]
+
+		// The unique index catches anything added since the conflict check
+		switch {
+		case err == nil:
+			written = append(written, i)
+		case errors.Is(err, ErrConflict):
+			items[i].Err = ErrConflict
+		default:
+			items[i].Err = fmt.Errorf("failed to add spell to DB: %w", err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("InsertBatchItems.Failed", len(pending)-len(written)))
+
+	if atomic && abortBatch(items) {
+		return nil, nil
+	}
+
+	return written, nil
+}
+
+// forEachLimited calls fn for every index up to n, running at most limit of
+// them at once
+func forEachLimited(n int, limit int, fn func(i int)) {
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// parseBatchItem reads one spell in a batch, keeping its name for the result
+// even if it's not valid
+func parseBatchItem(ctx context.Context, db Store, index int, raw json.RawMessage, strict bool) BatchItem {
+	item := BatchItem{Index: index}
+
+	var named struct {
+		Name string `json:"name"`
+	}
+	if json.Unmarshal(raw, &named) == nil {
+		item.Name = named.Name
+	}
+
+	if strict {
+		if err := checkSpellFields(raw); err != nil {
+			item.Err = err
+			return item
+		}
+	}
+
+	item.Spell, item.Err = ParseSpell(ctx, db, raw)
+	if item.Err == nil {
+		item.Name = item.Spell.Name
+		item.Err = checkSpellCharacters(item.Spell, nil)
+	}
+
+	return item
+}
+
+// abortBatch marks every spell in a batch that hasn't failed with
+// ErrBatchAborted, if any of them have failed. Reports whether it did.
+func abortBatch(items []BatchItem) bool {
+	failed := -1
+	for i := range items {
+		if items[i].Err != nil {
+			failed = i
+			break
+		}
+	}
+	if failed < 0 {
+		return false
+	}
+
+	for i := range items {
+		if items[i].Err == nil {
+			items[i].Err = fmt.Errorf("%w: spell %d failed", ErrBatchAborted, failed)
+		}
+	}
+
+	return true
+}
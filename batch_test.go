@@ -0,0 +1,275 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+	"gopkg.in/launchdarkly/go-sdk-common.v2/lduser"
+)
+
+// failingStore fails the nth spell written to it, for testing what happens
+// when a write fails part way through a batch
+type failingStore struct {
+	*db.MemoryDB
+	failOn int
+	writes int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (s *failingStore) AddSpell(ctx context.Context, spell []byte) error {
+	s.writes++
+	if s.writes == s.failOn {
+		return errWriteFailed
+	}
+	return s.MemoryDB.AddSpell(ctx, spell)
+}
+
+func (s *failingStore) AddSpells(ctx context.Context, spells [][]byte) []error {
+	errs := make([]error, len(spells))
+	for i, spell := range spells {
+		errs[i] = s.AddSpell(ctx, spell)
+	}
+	return errs
+}
+
+// AddSpellsAtomic writes nothing if the failing write is in the batch, like
+// an aborted transaction
+func (s *failingStore) AddSpellsAtomic(ctx context.Context, spells [][]byte) ([]error, error) {
+	errs := make([]error, len(spells))
+	for i := range spells {
+		s.writes++
+		if s.writes == s.failOn {
+			errs[i] = errWriteFailed
+			return errs, nil
+		}
+	}
+	return s.MemoryDB.AddSpellsAtomic(ctx, spells)
+}
+
+// standaloneStore can't run transactions, like mongo that isn't a replica set
+type standaloneStore struct {
+	*db.MemoryDB
+}
+
+func (s standaloneStore) SupportsTransactions() bool {
+	return false
+}
+
+func batchData(spells ...string) []json.RawMessage {
+	data := make([]json.RawMessage, len(spells))
+	for i, s := range spells {
+		data[i] = json.RawMessage(s)
+	}
+	return data
+}
+
+func TestAddSpellBatch(t *testing.T) {
+	ctx := context.Background()
+
+	spells := batchData(
+		`{"name":"Fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"Shield","description":"Block","metadata":{"system":"test1"}}`,
+		`{"name":"Cure Wounds","metadata":{"system":"test1"}}`,
+		`{"name":"fireball","description":"Again","metadata":{"system":"test1"}}`,
+	)
+
+	testCases := []struct {
+		atomic bool
+		want   []error
+		added  int
+	}{
+		{false, []error{nil, nil, spellapi.ErrValidation, spellapi.ErrConflict}, 2},
+		{true, []error{spellapi.ErrBatchAborted, spellapi.ErrBatchAborted, spellapi.ErrValidation, spellapi.ErrConflict}, 0},
+	}
+
+	for _, tc := range testCases {
+		store := db.NewMemoryDB()
+
+		items, err := spellapi.AddSpellBatch(ctx, store, spells, tc.atomic, false, "tester")
+		if err != nil {
+			t.Fatalf("AddSpellBatch(atomic=%v) err = %v; want nil", tc.atomic, err)
+		}
+
+		for i, want := range tc.want {
+			if items[i].Index != i || (want == nil && items[i].Err != nil) || !errors.Is(items[i].Err, want) {
+				t.Errorf("AddSpellBatch(atomic=%v) item %d = %d, %v; want %v", tc.atomic, i, items[i].Index, items[i].Err, want)
+			}
+		}
+		if items[2].Name != "Cure Wounds" {
+			t.Errorf("AddSpellBatch() item 2 name = %q; want the name of the invalid spell", items[2].Name)
+		}
+
+		all, err := spellapi.GetAllSpell(ctx, store, url.Values{})
+		if err != nil || len(all) != tc.added {
+			t.Errorf("GetAllSpell() after atomic=%v = %d spells, %v; want %d", tc.atomic, len(all), err, tc.added)
+		}
+	}
+
+	// A spell that fails to write means none of them are added
+	store := &failingStore{MemoryDB: db.NewMemoryDB(), failOn: 2}
+	items, err := spellapi.AddSpellBatch(ctx, store, spells[:2], true, false, "tester")
+	if err != nil {
+		t.Fatalf("AddSpellBatch(failed write) err = %v; want nil", err)
+	}
+	if !errors.Is(items[0].Err, spellapi.ErrBatchAborted) || !errors.Is(items[1].Err, errWriteFailed) {
+		t.Errorf("AddSpellBatch(failed write) errs = %v, %v; want aborted and the write failure", items[0].Err, items[1].Err)
+	}
+
+	all, err := spellapi.GetAllSpell(ctx, store, url.Values{})
+	if err != nil || len(all) != 0 {
+		t.Errorf("GetAllSpell() after a failed write = %v, %v; want nothing added", all, err)
+	}
+
+	revisions, err := store.GetRevisions(ctx, items[0].Spell.ID)
+	if err != nil || len(revisions) != 0 {
+		t.Errorf("GetRevisions() after a failed write = %v, %v; want none recorded", revisions, err)
+	}
+
+	// Without transactions an atomic batch is refused before anything's
+	// written
+	standalone := standaloneStore{db.NewMemoryDB()}
+	_, err = spellapi.AddSpellBatch(ctx, standalone, spells[:2], true, false, "tester")
+	if !errors.Is(err, spellapi.ErrTransactionsUnsupported) {
+		t.Errorf("AddSpellBatch(no transactions) err = %v; want %v", err, spellapi.ErrTransactionsUnsupported)
+	}
+	all, err = spellapi.GetAllSpell(ctx, standalone, url.Values{})
+	if err != nil || len(all) != 0 {
+		t.Errorf("GetAllSpell() after no transactions = %v, %v; want nothing added", all, err)
+	}
+
+	// Strict mode reports the unknown field against the spell it's in
+	items, err = spellapi.AddSpellBatch(ctx, db.NewMemoryDB(), batchData(`{"name":"Fireball","description":"Big boom","spellData":{},"metadata":{"system":"test1"}}`), false, true, "tester")
+	var validationErrs spellapi.ValidationErrors
+	if err != nil || !errors.As(items[0].Err, &validationErrs) || validationErrs[0].Field != "spellData" {
+		t.Errorf("AddSpellBatch(strict) = %v, %v; want spellData to be unknown", items[0].Err, err)
+	}
+}
+
+// testFlags turns every feature flag on or off
+type testFlags bool
+
+func (testFlags) GetUser(ctx context.Context, r *http.Request) lduser.User {
+	return lduser.NewUser("tester")
+}
+
+func (testFlags) GetIntFlag(ctx context.Context, flag string, user lduser.User) int {
+	return 0
+}
+
+func (f testFlags) GetBoolFlag(ctx context.Context, flag string, user lduser.User) bool {
+	return bool(f)
+}
+
+func TestPostSpellHandlerMultipost(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store, `{"name":"Shield","description":"Block","metadata":{"system":"test1"}}`)
+
+	service := spellapi.NewSpellService(store, testFlags(true), 0)
+
+	body := `{"data":[
+		{"name":"Fireball","description":"Big boom","metadata":{"system":"test1"}},
+		{"name":"Shield","description":"Block","metadata":{"system":"test1"}},
+		{"name":"Cure Wounds","metadata":{"system":"test1"}},
+		{"name":"Fireball","description":"Again","metadata":{"system":"test1"}}
+	]}`
+	r := httptest.NewRequest("POST", "/spells", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	service.PostSpellHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PostSpellHandler() status = %d; want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var resp spellapi.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal() err = %v; want nil", err)
+	}
+
+	codes := []int{}
+	for _, d := range resp.Data {
+		codes = append(codes, d.ResponseCode)
+	}
+	if want := []int{http.StatusConflict, http.StatusBadRequest, http.StatusConflict}; resp.Count != 4 || !reflect.DeepEqual(codes, want) {
+		t.Errorf("PostSpellHandler() = %d spells, errors %v; want 4 spells, errors %v", resp.Count, codes, want)
+	}
+
+	all, err := spellapi.GetAllSpell(ctx, store, url.Values{})
+	if err != nil || len(all) != 2 {
+		t.Errorf("GetAllSpell() after multipost = %d spells, %v; want Shield and Fireball", len(all), err)
+	}
+
+	// A body that isn't a list of spells is the client's mistake
+	r = httptest.NewRequest("POST", "/spells", strings.NewReader(`{"data":{"name":"Fireball"}}`))
+	w = httptest.NewRecorder()
+	service.PostSpellHandler(w, r)
+
+	var problem spellapi.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Unmarshal() err = %v; want nil", err)
+	}
+	if w.Code != http.StatusBadRequest || problem.Type != spellapi.ValidationProblem {
+		t.Errorf("PostSpellHandler(invalid) = %d, %+v; want %d %s", w.Code, problem, http.StatusBadRequest, spellapi.ValidationProblem)
+	}
+}
+
+func TestBatchSpellHandler(t *testing.T) {
+	// Doesn't need any feature flags
+	service := spellapi.NewSpellService(db.NewMemoryDB(), testFlags(false), 0)
+
+	body := `{"data":[
+		{"name":"Fireball","description":"Big boom","metadata":{"system":"test1"}},
+		{"name":"Shield","metadata":{"system":"test1"}}
+	]}`
+
+	// rollbackOnError is still accepted as the old name for atomic
+	for _, target := range []string{"/spells:batch?atomic=true", "/spells:batch?rollbackOnError=true"} {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		service.BatchSpellHandler(w, r)
+
+		if w.Code != http.StatusMultiStatus || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("BatchSpellHandler(%s) = %d, %q; want %d, application/json", target, w.Code, w.Header().Get("Content-Type"), http.StatusMultiStatus)
+		}
+
+		var resp spellapi.BatchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Unmarshal() err = %v; want nil", err)
+		}
+		if !resp.Atomic || resp.Created != 0 || resp.Results[0].Status != http.StatusFailedDependency || resp.Results[1].Status != http.StatusBadRequest {
+			t.Errorf("BatchSpellHandler(%s) = %+v; want neither spell added", target, resp)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/spells:batch?atomic=maybe", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	service.BatchSpellHandler(w, r)
+
+	var problem spellapi.Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusBadRequest || problem.Field != "atomic" {
+		t.Errorf("BatchSpellHandler(atomic=maybe) = %d, %+v; want %d for atomic", w.Code, problem, http.StatusBadRequest)
+	}
+
+	// A database that can't run transactions can't do an atomic batch
+	service = spellapi.NewSpellService(standaloneStore{db.NewMemoryDB()}, testFlags(false), 0)
+	r = httptest.NewRequest("POST", "/spells:batch?atomic=true", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	service.BatchSpellHandler(w, r)
+
+	problem = spellapi.Problem{}
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	if w.Code != http.StatusNotImplemented || problem.Type != spellapi.AtomicProblem || problem.Detail == "" {
+		t.Errorf("BatchSpellHandler(no transactions) = %d, %+v; want %d %s", w.Code, problem, http.StatusNotImplemented, spellapi.AtomicProblem)
+	}
+}
@@ -2,21 +2,232 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+var (
+	// ErrSpellNotFound is returned when an update or delete doesn't match any
+	// spell
+	ErrSpellNotFound = errors.New("spell not found")
+	// ErrSpellAlreadyExists is returned when a write would break the unique
+	// name and system or alias and system indexes
+	ErrSpellAlreadyExists = errors.New("spell already exists for this system")
+	// ErrUnavailable is wrapped around errors from failing to reach the
+	// database, as opposed to the database rejecting a query
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrRevisionExists is returned when a revision number has already been
+	// used for a spell, usually by a concurrent edit
+	ErrRevisionExists = errors.New("revision already exists for this spell")
+	// ErrSystemNotFound is returned when an update or delete doesn't match any
+	// system
+	ErrSystemNotFound = errors.New("system not found")
+	// ErrSystemAlreadyExists is returned when a write would break the unique
+	// system key index
+	ErrSystemAlreadyExists = errors.New("system already exists")
+	// ErrTransactionsUnsupported is returned when writes have to happen
+	// together but mongo isn't a replica set or sharded cluster, or otherwise
+	// can't run them in a transaction
+	ErrTransactionsUnsupported = errors.New("database doesn't support transactions")
+)
+
+// AddSpells writes spells in chunks of this many, with up to
+// maxConcurrentInserts chunks at once so a large import doesn't swamp the DB
+const (
+	insertChunkSize      = 100
+	maxConcurrentInserts = 4
+)
+
+// Error codes mongo uses for a duplicate key in a write error
+var duplicateKeyCodes = map[int]bool{
+	11000: true,
+	11001: true,
+	12582: true,
+}
+
+// Error code mongo uses when dropping an index that doesn't exist
+const indexNotFoundCode = 27
+
+// errBatchFailed aborts the AddSpellsAtomic transaction when a spell fails
+var errBatchFailed = errors.New("spells in the batch failed")
+
+// Error codes mongo uses when it can't run a command in a transaction
+var transactionUnsupportedCodes = map[int32]bool{
+	20:  true,
+	115: true,
+	263: true,
+}
+
+// The fields text search looks at and how much a match in each counts for.
+// searchText holds the string values from spelldata, and a name match counts
+// in both name and displayName.
+var textWeights = map[string]int{
+	"name":        5,
+	"displayName": 5,
+	"description": 5,
+	"searchText":  1,
+}
+
+// Indexes that ConnectDb makes sure exist on the spells collection
+var spellIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "name", Value: 1},
+			{Key: "metadata.system", Value: 1},
+		},
+		Options: options.Index().SetName("name_system_unique").SetUnique(true),
+	},
+	{
+		// Aliases are looked up the same way as names and, like names, only
+		// belong to one spell in each system. Spells without aliases aren't
+		// indexed so they don't all conflict on a missing value.
+		Keys: bson.D{
+			{Key: "metadata.system", Value: 1},
+			{Key: "aliasKeys", Value: 1},
+		},
+		Options: options.Index().
+			SetName("aliases_system_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"aliasKeys": bson.M{"$type": "string"}}),
+	},
+	{
+		// Used by ?updatedSince=
+		Keys: bson.D{
+			{Key: "metadata.updatedAt", Value: 1},
+		},
+		Options: options.Index().SetName("metadata_updated"),
+	},
+}
+
+// Covers the fields in textWeights, so metadata such as the creator isn't
+// searched. Not every server can build a text index, so it's created
+// separately from spellIndexes and search falls back to ranking spells in the
+// app without it.
+var spellTextIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "name", Value: "text"},
+		{Key: "displayName", Value: "text"},
+		{Key: "description", Value: "text"},
+		{Key: "searchText", Value: "text"},
+	},
+	Options: options.Index().SetName("spell_search_text").SetWeights(textWeights),
+}
+
+// Indexes that ConnectDb makes sure exist on the revisions collection
+var revisionIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "spellid", Value: 1},
+			{Key: "revision", Value: 1},
+		},
+		Options: options.Index().SetName("spell_revision_unique").SetUnique(true),
+	},
+}
+
+// Indexes that ConnectDb makes sure exist on the systems collection
+var systemIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "key", Value: 1},
+		},
+		Options: options.Index().SetName("key_unique").SetUnique(true),
+	},
+	{
+		Keys: bson.D{
+			{Key: "aliasKeys", Value: 1},
+		},
+		Options: options.Index().SetName("aliases"),
+	},
+}
+
+// Order of GetSystems results
+var systemSort = bson.D{
+	{Key: "key", Value: 1},
+}
+
+// Order of GetRevisions results, oldest first
+var revisionSort = bson.D{
+	{Key: "revision", Value: 1},
+}
+
+// Order spells are returned in when paging, with _id breaking any ties so
+// pages are stable
+var spellPageSort = bson.D{
+	{Key: "name", Value: 1},
+	{Key: "metadata.system", Value: 1},
+	{Key: "_id", Value: 1},
+}
+
+// Order of GetSpellNames results, which the unique index makes stable
+var spellNameSort = bson.D{
+	{Key: "name", Value: 1},
+	{Key: "metadata.system", Value: 1},
+}
+
+// Order of GetTrash results, most recently deleted first
+var trashSort = bson.D{
+	{Key: "deleted.at", Value: -1},
+	{Key: "_id", Value: 1},
+}
+
+// Fields returned by GetSpellNames
+var spellNameProjection = bson.M{
+	"_id":             0,
+	"name":            1,
+	"displayName":     1,
+	"metadata.system": 1,
+}
+
+// DuplicateSpell is a name and system shared by more than one spell, which
+// stops the unique indexes from being created. Alias is set when Name is an
+// alias key rather than the spells' names.
+type DuplicateSpell struct {
+	Name   string        `bson:"name"`
+	System string        `bson:"system"`
+	Alias  bool          `bson:"alias"`
+	Count  int           `bson:"count"`
+	Ids    []interface{} `bson:"ids"`
+}
+
 type DB struct {
 	*mongo.Client
+	// Whether EnsureIndexes created the text index SearchSpells uses
+	textIndex bool
+	// Whether mongo is a replica set or sharded cluster, which transactions
+	// need
+	transactions bool
 }
 
-// Connect to the specified mongo instance using the context for timeout
+// Connect to the specified mongo instance and make sure the spell indexes exist
 func ConnectDb(uri string) (*DB, error) {
+	db, err := ConnectDbWithoutIndexes(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.EnsureIndexes(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spell indexes, existing duplicate spells can be found with -report-duplicates: %v", err)
+	}
+
+	return db, nil
+}
+
+// Connect to the specified mongo instance without touching indexes, for admin
+// tasks that need to run before the indexes can be created
+func ConnectDbWithoutIndexes(uri string) (*DB, error) {
 	ctx := context.Background()
 	clientOptions := options.Client().ApplyURI(uri).SetDirect(true)
 	c, err := mongo.NewClient(clientOptions)
@@ -34,12 +245,144 @@ func ConnectDb(uri string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{c}, nil
+	transactions, err := supportsTransactions(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{Client: c, transactions: transactions}, nil
+}
+
+// supportsTransactions checks whether mongo is a replica set member or a
+// mongos with sessions, the deployments that can run transactions
+func supportsTransactions(ctx context.Context, c *mongo.Client) (bool, error) {
+	var hello bson.M
+	err := c.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+
+	if hello["logicalSessionTimeoutMinutes"] == nil {
+		return false, nil
+	}
+
+	return hello["setName"] != nil || hello["msg"] == "isdbgrid", nil
+}
+
+// withTransaction runs fn in a transaction so either all of its writes happen
+// or none of them do. It returns ErrTransactionsUnsupported if mongo can't run
+// transactions, and fn's writes are rolled back if it finds that out part way
+// through.
+func (db *DB) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !db.transactions {
+		return ErrTransactionsUnsupported
+	}
+
+	session, err := db.StartSession()
+	if err != nil {
+		return storeError(err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && transactionUnsupportedCodes[cmdErr.Code] {
+		return ErrTransactionsUnsupported
+	}
+
+	return storeError(err)
+}
+
+func (db *DB) EnsureIndexes(ctx context.Context) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.EnsureIndexes")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	// The alias index used to allow duplicates and has been replaced by
+	// aliases_system_unique
+	err := dropIndex(ctx, collection, "aliases_system")
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.EnsureIndexes.Error", err.Error()))
+		return err
+	}
+
+	names, err := collection.Indexes().CreateMany(ctx, spellIndexes)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.EnsureIndexes.Error", err.Error()))
+		return err
+	}
+
+	revisions := db.Database("spellapi").Collection("revisions")
+
+	revisionNames, err := revisions.Indexes().CreateMany(ctx, revisionIndexes)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.EnsureIndexes.Error", err.Error()))
+		return err
+	}
+
+	systems := db.Database("spellapi").Collection("systems")
+
+	systemNames, err := systems.Indexes().CreateMany(ctx, systemIndexes)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.EnsureIndexes.Error", err.Error()))
+		return err
+	}
+
+	names = append(names, revisionNames...)
+	span.SetAttributes(attribute.String("Mongo.EnsureIndexes.Names", fmt.Sprint(append(names, systemNames...))))
+
+	// The text index used to cover every field and there can only be one,
+	// so it has to go before spell_search_text can be created
+	var textName string
+	err = dropIndex(ctx, collection, "spell_text")
+	if err == nil {
+		textName, err = collection.Indexes().CreateOne(ctx, spellTextIndex)
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.EnsureIndexes.TextError", err.Error()))
+		log.Printf("failed to create the text index, search will rank spells without it: %v", err)
+		db.textIndex = false
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("Mongo.EnsureIndexes.TextIndex", textName))
+	db.textIndex = true
+
+	return nil
+}
+
+// dropIndex removes an index that's been replaced, if it's still there
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexNotFoundCode {
+		return nil
+	}
+
+	return err
 }
 
 //	collection := mc.Database("reminders").Collection("reminders")
 
-func runQuery(ctx context.Context, mc *mongo.Collection, query interface{}) ([]bson.M, error) {
+// storeError wraps errors from failing to reach mongo in ErrUnavailable so
+// they can be told apart from the rest
+func storeError(err error) error {
+	var selectionErr topology.ServerSelectionError
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) ||
+		errors.Is(err, mongo.ErrClientDisconnected) || errors.As(err, &selectionErr) {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	return err
+}
+
+func runQuery(ctx context.Context, mc *mongo.Collection, query interface{}, opts ...*options.FindOptions) ([]bson.M, error) {
 
 	tracer := otel.Tracer("Encantus")
 	ctx, span := tracer.Start(ctx, "Mongo.RunQuery")
@@ -50,16 +393,16 @@ func runQuery(ctx context.Context, mc *mongo.Collection, query interface{}) ([]b
 		attribute.String("Mongo.RunQuery.Database", mc.Database().Name()),
 	)
 
-	cursor, err := mc.Find(ctx, query)
+	cursor, err := mc.Find(ctx, query, opts...)
 	if err != nil {
 		span.SetAttributes(attribute.String("Mongo.RunQuery.Error", err.Error()))
-		return nil, err
+		return nil, storeError(err)
 	}
 
 	var results []bson.M
 	if err = cursor.All(ctx, &results); err != nil {
 		span.SetAttributes(attribute.String("Mongo.RunQuery.Error", err.Error()))
-		return nil, err
+		return nil, storeError(err)
 	}
 	span.SetAttributes(
 		attribute.Int("Mongo.RunQuery.Results.Count", len(results)),
@@ -81,9 +424,12 @@ func writeDbObject(ctx context.Context, mc *mongo.Collection, obj []byte) error
 	)
 
 	res, err := mc.InsertOne(ctx, obj)
-	if err != nil {
+	if err != nil && mongo.IsDuplicateKeyError(err) {
 		span.SetAttributes(attribute.String("Mongo.WriteObject.Error", err.Error()))
-		return err
+		return ErrSpellAlreadyExists
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.WriteObject.Error", err.Error()))
+		return storeError(err)
 	}
 
 	span.SetAttributes(attribute.String("Mongo.WriteObject.Id", fmt.Sprint(res.InsertedID)))
@@ -91,6 +437,38 @@ func writeDbObject(ctx context.Context, mc *mongo.Collection, obj []byte) error
 	return nil
 }
 
+func replaceDbObject(ctx context.Context, mc *mongo.Collection, query interface{}, obj []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.ReplaceDbObject")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.RunQuery.Collection", mc.Name()),
+		attribute.String("Mongo.RunQuery.Database", mc.Database().Name()),
+	)
+
+	res, err := mc.ReplaceOne(ctx, query, obj)
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		span.SetAttributes(attribute.String("Mongo.ReplaceDbObject.Error", err.Error()))
+		return ErrSpellAlreadyExists
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.ReplaceDbObject.Error", err.Error()))
+		return storeError(err)
+	}
+
+	span.SetAttributes(
+		attribute.Int64("Mongo.ReplaceDbObject.MatchedCount", res.MatchedCount),
+		attribute.Int64("Mongo.ReplaceDbObject.ModifiedCount", res.ModifiedCount),
+	)
+
+	if res.MatchedCount == 0 {
+		return ErrSpellNotFound
+	}
+
+	return nil
+}
+
 func deleteDbObject(ctx context.Context, mc *mongo.Collection, query interface{}) error {
 
 	tracer := otel.Tracer("Encantus")
@@ -105,11 +483,15 @@ func deleteDbObject(ctx context.Context, mc *mongo.Collection, query interface{}
 	deleted, err := mc.DeleteOne(ctx, query)
 	if err != nil {
 		span.SetAttributes(attribute.String("Mongo.DeleteDbObject.Error", err.Error()))
-		return err
+		return storeError(err)
 	}
 
 	span.SetAttributes(attribute.Int64("Mongo.DeleteDbObject.DeletedCount", deleted.DeletedCount))
 
+	if deleted.DeletedCount == 0 {
+		return ErrSpellNotFound
+	}
+
 	return nil
 }
 
@@ -126,7 +508,7 @@ func getDistinctValues(ctx context.Context, mc *mongo.Collection, key string) ([
 	results, err := mc.Distinct(ctx, key, bson.D{})
 	if err != nil {
 		span.SetAttributes(attribute.String("Mongo.getDistinctValues.Error", err.Error()))
-		return nil, err
+		return nil, storeError(err)
 	}
 
 	span.SetAttributes(attribute.String("Mongo.getDistinctValues.RawResults", fmt.Sprintf("%v", results)))
@@ -168,13 +550,13 @@ func getKeys(ctx context.Context, mc *mongo.Collection) ([]bson.M, error) {
 	cursor, err := mc.Aggregate(ctx, query)
 	if err != nil {
 		span.SetAttributes(attribute.String("Mongo.GetKeys.Error", err.Error()))
-		return nil, err
+		return nil, storeError(err)
 	}
 
 	var results []bson.M
 	if err = cursor.All(ctx, &results); err != nil {
 		span.SetAttributes(attribute.String("Mongo.GetKeys.Error", err.Error()))
-		return nil, err
+		return nil, storeError(err)
 	}
 	span.SetAttributes(attribute.Int("Mongo.GetKeys.Results.Count", len(results)))
 
@@ -202,6 +584,135 @@ func (db *DB) GetSpell(ctx context.Context, search bson.M) ([]bson.M, error) {
 	return result, nil
 }
 
+func (db *DB) GetSpellPage(ctx context.Context, search bson.M, skip int64, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetSpellPage")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.GetSpellPage.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Mongo.GetSpellPage.Skip", skip),
+		attribute.Int64("Mongo.GetSpellPage.Limit", limit),
+	)
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	findOptions := options.Find().SetSort(spellPageSort).SetSkip(skip).SetLimit(limit)
+
+	result, err := runQuery(ctx, collection, search, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetSpellPage.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (db *DB) CountSpells(ctx context.Context, search bson.M) (int64, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.CountSpells")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.CountSpells.Query", fmt.Sprintf("%v", search)))
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	count, err := collection.CountDocuments(ctx, search)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.CountSpells.Error", err.Error()))
+		return 0, storeError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("Mongo.CountSpells.Count", count))
+
+	return count, nil
+}
+
+func (db *DB) SearchSpells(ctx context.Context, text string, search bson.M, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.SearchSpells")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.SearchSpells.Text", text),
+		attribute.String("Mongo.SearchSpells.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Mongo.SearchSpells.Limit", limit),
+	)
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	span.SetAttributes(attribute.Bool("Mongo.SearchSpells.TextIndex", db.textIndex))
+
+	if !db.textIndex {
+		// Without the text index load the spells and rank them the same way
+		// the in-memory store does
+		results, err := runQuery(ctx, collection, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Mongo.SearchSpells.Error", err.Error()))
+			return nil, err
+		}
+
+		return rankTextMatches(results, text, limit), nil
+	}
+
+	query := bson.M{
+		"$text": bson.M{
+			"$search": text,
+		},
+	}
+	for k, v := range search {
+		query[k] = v
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}}).
+		SetLimit(limit)
+
+	result, err := runQuery(ctx, collection, query, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.SearchSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSpellNames returns just the name and system of matching spells, in name
+// order, which is much cheaper than loading the whole spell
+func (db *DB) GetSpellNames(ctx context.Context, search bson.M, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetSpellNames")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.GetSpellNames.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Mongo.GetSpellNames.Limit", limit),
+	)
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	// Sorting on the fields in the unique index lets a name prefix query use
+	// the index, only the names and system are loaded
+	findOptions := options.Find().
+		SetProjection(spellNameProjection).
+		SetSort(spellNameSort).
+		SetLimit(limit)
+
+	result, err := runQuery(ctx, collection, search, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetSpellNames.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func (db *DB) AddSpell(ctx context.Context, spell []byte) error {
 
 	tracer := otel.Tracer("Encantus")
@@ -221,63 +732,811 @@ func (db *DB) AddSpell(ctx context.Context, spell []byte) error {
 	return nil
 }
 
-func (db *DB) DeleteSpell(ctx context.Context, spell bson.M) error {
+// AddSpells writes many spells at once with unordered InsertMany calls, so
+// one failing doesn't stop the rest. The result has an error for each spell,
+// nil if it was written and ErrSpellAlreadyExists if the unique index
+// rejected it. If a chunk fails without saying which spells were written,
+// they're looked up by _id so only the ones that are missing are reported.
+func (db *DB) AddSpells(ctx context.Context, spells [][]byte) []error {
 
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(ctx, "Mongo.DeleteSpell")
+	ctx, span := tracer.Start(ctx, "Mongo.AddSpells")
 	defer span.End()
 
-	span.SetAttributes(attribute.String("Mongo.DeleteSpell.Spell", fmt.Sprintf("%v", spell)))
+	span.SetAttributes(attribute.Int("Mongo.AddSpells.Count", len(spells)))
 
 	collection := db.Database("spellapi").Collection("spells")
 
-	err := deleteDbObject(ctx, collection, spell)
-	if err != nil {
-		span.SetAttributes(attribute.String("Mongo.DeleteSpell.Error", err.Error()))
-		return err
+	errs := make([]error, len(spells))
+	limit := make(chan struct{}, maxConcurrentInserts)
+	var wg sync.WaitGroup
+
+	for start := 0; start < len(spells); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(spells) {
+			end = len(spells)
+		}
+
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			// Each chunk only writes its own part of errs
+			insertChunk(ctx, collection, spells[start:end], errs[start:end])
+		}(start, end)
 	}
 
-	return nil
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	span.SetAttributes(attribute.Int("Mongo.AddSpells.Failed", failed))
+
+	return errs
 }
 
-func (db *DB) GetMetadataValues(ctx context.Context, metadataName string) ([]string, error) {
+// SupportsTransactions reports whether mongo is a replica set or sharded
+// cluster, so AddSpellsAtomic can be used
+func (db *DB) SupportsTransactions() bool {
+	return db.transactions
+}
+
+// AddSpellsAtomic writes every spell in a transaction, so either they're all
+// added or none are and readers never see part of the batch. If any fail the
+// errors say which, and the spells after the first chunk with a failure
+// aren't tried. It fails with ErrTransactionsUnsupported if mongo can't run
+// transactions.
+func (db *DB) AddSpellsAtomic(ctx context.Context, spells [][]byte) ([]error, error) {
+
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(ctx, "Mongo.GetMetadataValues")
+	ctx, span := tracer.Start(ctx, "Mongo.AddSpellsAtomic")
 	defer span.End()
 
+	span.SetAttributes(attribute.Int("Mongo.AddSpellsAtomic.Count", len(spells)))
+
 	collection := db.Database("spellapi").Collection("spells")
 
-	values, err := getDistinctValues(ctx, collection, metadataName)
-	if err != nil {
-		span.SetAttributes(attribute.String("Mongo.GetMetadataValues.Error", err.Error()))
+	var errs []error
+	err := db.withTransaction(ctx, func(ctx context.Context) error {
+		// A session can't be shared between goroutines, so the chunks are
+		// written one at a time
+		errs = make([]error, len(spells))
+		for start := 0; start < len(spells); start += insertChunkSize {
+			end := start + insertChunkSize
+			if end > len(spells) {
+				end = len(spells)
+			}
+
+			insertChunk(ctx, collection, spells[start:end], errs[start:end])
+			for _, err := range errs[start:end] {
+				if err != nil {
+					return errBatchFailed
+				}
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchFailed) {
+		span.SetAttributes(attribute.String("Mongo.AddSpellsAtomic.Error", err.Error()))
+		return errs, nil
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.AddSpellsAtomic.Error", err.Error()))
 		return nil, err
 	}
 
-	return values, nil
+	return errs, nil
 }
 
-func (db *DB) GetMetadataNames(ctx context.Context) ([]string, error) {
+// insertChunk writes one chunk of AddSpells, filling in errs for the spells
+// that failed
+func insertChunk(ctx context.Context, mc *mongo.Collection, spells [][]byte, errs []error) {
+
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(ctx, "Mongo.GetMetadataNames")
+	ctx, span := tracer.Start(ctx, "Mongo.InsertChunk")
 	defer span.End()
 
-	collection := db.Database("spellapi").Collection("spells")
+	docs := make([]interface{}, len(spells))
+	for i, s := range spells {
+		docs[i] = s
+	}
 
-	keysRaw, err := getKeys(ctx, collection)
-	if err != nil {
-		span.SetAttributes(attribute.String("Mongo.GetMetadataNames.Error", err.Error()))
-		return nil, err
+	res, err := mc.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if res != nil {
+		span.SetAttributes(attribute.Int("Mongo.InsertChunk.Inserted", len(res.InsertedIDs)))
 	}
 
-	exist := make(map[string]bool)
-	keys := []string{"system"}
-	for _, v := range keysRaw {
-		if _, ok := exist[v["data"].(string)]; !ok {
-			exist[v["data"].(string)] = true
-			keys = append(keys, v["data"].(string))
+	var bulkErr mongo.BulkWriteException
+	isBulkErr := errors.As(err, &bulkErr)
+	if isBulkErr {
+		span.SetAttributes(attribute.Int("Mongo.InsertChunk.WriteErrors", len(bulkErr.WriteErrors)))
+		for _, we := range bulkErr.WriteErrors {
+			if we.Index < 0 || we.Index >= len(errs) {
+				continue
+			}
+			if duplicateKeyCodes[we.Code] {
+				errs[we.Index] = ErrSpellAlreadyExists
+			} else {
+				errs[we.Index] = we
+			}
+		}
+	}
+
+	if err == nil || (isBulkErr && bulkErr.WriteConcernError == nil) {
+		return
+	}
+
+	// A network error, timeout or write concern error doesn't say which
+	// spells were written, so look for them rather than reporting spells
+	// that were saved as failed
+	span.SetAttributes(attribute.String("Mongo.InsertChunk.Error", err.Error()))
+
+	unsure := map[int]string{}
+	ids := []interface{}{}
+	for i := range errs {
+		if errs[i] != nil {
+			continue
+		}
+
+		var id interface{}
+		v, lookupErr := bson.Raw(spells[i]).LookupErr("_id")
+		if lookupErr != nil || v.Unmarshal(&id) != nil {
+			errs[i] = storeError(err)
+			continue
+		}
+
+		unsure[i] = fmt.Sprint(id)
+		ids = append(ids, id)
+	}
+
+	written, findErr := writtenIds(ctx, mc, ids)
+	if findErr != nil {
+		span.SetAttributes(attribute.String("Mongo.InsertChunk.RecheckError", findErr.Error()))
+	}
+
+	for i, id := range unsure {
+		if !written[id] {
+			errs[i] = storeError(err)
+		}
+	}
+}
+
+// writtenIds looks up which of ids are in a collection
+func writtenIds(ctx context.Context, mc *mongo.Collection, ids []interface{}) (map[string]bool, error) {
+	written := map[string]bool{}
+	if len(ids) == 0 {
+		return written, nil
+	}
+
+	cursor, err := mc.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return written, err
+	}
+
+	var found []bson.M
+	if err := cursor.All(ctx, &found); err != nil {
+		return written, err
+	}
+
+	for _, doc := range found {
+		written[fmt.Sprint(doc["_id"])] = true
+	}
+
+	return written, nil
+}
+
+func (db *DB) UpdateSpell(ctx context.Context, search bson.M, spell []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.UpdateSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.UpdateSpell.Query", fmt.Sprintf("%v", search)),
+		attribute.String("Mongo.UpdateSpell.Spell", string(spell)),
+	)
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	err := replaceDbObject(ctx, collection, search, spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.UpdateSpell.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) DeleteSpell(ctx context.Context, spell bson.M) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.DeleteSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.DeleteSpell.Spell", fmt.Sprintf("%v", spell)))
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	err := deleteDbObject(ctx, collection, spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.DeleteSpell.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// TrashSpell moves the first spell matching search into the trash collection,
+// recording when it was deleted and by who. The copy and the delete run in a
+// transaction if mongo supports them, and otherwise the spell's copied before
+// it's removed so a failure part way through can't lose it.
+func (db *DB) TrashSpell(ctx context.Context, search bson.M, deletedBy string, deletedAt time.Time) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.TrashSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.TrashSpell.Query", fmt.Sprintf("%v", search)),
+		attribute.String("Mongo.TrashSpell.DeletedBy", deletedBy),
+	)
+
+	w := db.trashWrites()
+
+	move := func(ctx context.Context) error {
+		var spell bson.D
+		err := w.spells.FindOne(ctx, search).Decode(&spell)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrSpellNotFound
+		} else if err != nil {
+			return storeError(err)
+		}
+
+		id := spell.Map()["_id"]
+		span.SetAttributes(attribute.String("Mongo.TrashSpell.Id", fmt.Sprint(id)))
+
+		spell = append(spell, bson.E{Key: "deleted", Value: bson.D{
+			{Key: "at", Value: deletedAt},
+			{Key: "by", Value: deletedBy},
+		}})
+
+		return trashDocument(ctx, w, id, spell, search)
+	}
+
+	err := db.withTransaction(ctx, move)
+	if errors.Is(err, ErrTransactionsUnsupported) {
+		err = move(ctx)
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.TrashSpell.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// GetTrash returns deleted spells matching search, most recently deleted first
+func (db *DB) GetTrash(ctx context.Context, search bson.M) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.GetTrash.Query", fmt.Sprintf("%v", search)))
+
+	collection := db.Database("spellapi").Collection("trash")
+
+	findOptions := options.Find().SetSort(trashSort)
+
+	result, err := runQuery(ctx, collection, search, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetTrash.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// RestoreSpell moves a spell from the trash back into the spells collection,
+// keeping its _id. It runs in a transaction if mongo supports them, and
+// otherwise the trash copy is only removed once the spell is back.
+func (db *DB) RestoreSpell(ctx context.Context, id primitive.ObjectID) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.RestoreSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.RestoreSpell.Id", id.Hex()))
+
+	w := db.trashWrites()
+
+	restore := func(ctx context.Context) error {
+		var deleted bson.D
+		err := w.trash.FindOne(ctx, bson.M{"_id": id}).Decode(&deleted)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrSpellNotFound
+		} else if err != nil {
+			return storeError(err)
+		}
+
+		return restoreDocument(ctx, w, id, withoutDeleted(deleted))
+	}
+
+	err := db.withTransaction(ctx, restore)
+	if errors.Is(err, ErrTransactionsUnsupported) {
+		err = restore(ctx)
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.RestoreSpell.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// trashWrites are the writes that move a spell into and out of the trash
+type trashWrites interface {
+	// saveTrash adds the trash copy of a spell, replacing one left by an
+	// earlier attempt
+	saveTrash(ctx context.Context, id interface{}, spell bson.D) error
+	// deleteTrash removes the trash copy of a spell
+	deleteTrash(ctx context.Context, id interface{}) error
+	// insertSpell puts a spell back, failing with ErrSpellAlreadyExists if
+	// its _id or name is taken
+	insertSpell(ctx context.Context, spell bson.D) error
+	// deleteSpell removes the first spell matching search
+	deleteSpell(ctx context.Context, search bson.M) error
+	// hasSpell checks whether the spell with the _id is in the spells
+	hasSpell(ctx context.Context, id interface{}) (bool, error)
+}
+
+// trashDocument copies a spell into the trash and then deletes it. If the
+// delete fails the copy's removed again, and if that fails too the spell is in
+// both places, where trashing or restoring it again finishes the job.
+func trashDocument(ctx context.Context, w trashWrites, id interface{}, spell bson.D, search bson.M) error {
+	err := w.saveTrash(ctx, id, spell)
+	if err != nil {
+		return err
+	}
+
+	err = w.deleteSpell(ctx, search)
+	if err != nil {
+		w.deleteTrash(ctx, id)
+		return err
+	}
+
+	return nil
+}
+
+// restoreDocument puts a spell back and then removes its trash copy, so the
+// copy stays in the trash if anything fails. A spell that's back from an
+// earlier attempt that got that far only has its copy removed, but one that's
+// taken the name since fails with ErrSpellAlreadyExists.
+func restoreDocument(ctx context.Context, w trashWrites, id interface{}, spell bson.D) error {
+	err := w.insertSpell(ctx, spell)
+	if errors.Is(err, ErrSpellAlreadyExists) {
+		restored, findErr := w.hasSpell(ctx, id)
+		if findErr != nil {
+			return findErr
+		}
+		if !restored {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	err = w.deleteTrash(ctx, id)
+	if err != nil && !errors.Is(err, ErrSpellNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// mongoTrash does the trash writes on the spells and trash collections
+type mongoTrash struct {
+	spells *mongo.Collection
+	trash  *mongo.Collection
+}
+
+func (db *DB) trashWrites() mongoTrash {
+	return mongoTrash{
+		spells: db.Database("spellapi").Collection("spells"),
+		trash:  db.Database("spellapi").Collection("trash"),
+	}
+}
+
+func (w mongoTrash) saveTrash(ctx context.Context, id interface{}, spell bson.D) error {
+	_, err := w.trash.ReplaceOne(ctx, bson.M{"_id": id}, spell, options.Replace().SetUpsert(true))
+	return storeError(err)
+}
+
+func (w mongoTrash) deleteTrash(ctx context.Context, id interface{}) error {
+	return deleteDbObject(ctx, w.trash, bson.M{"_id": id})
+}
+
+func (w mongoTrash) insertSpell(ctx context.Context, spell bson.D) error {
+	obj, err := bson.Marshal(spell)
+	if err != nil {
+		return err
+	}
+
+	return writeDbObject(ctx, w.spells, obj)
+}
+
+func (w mongoTrash) deleteSpell(ctx context.Context, search bson.M) error {
+	return deleteDbObject(ctx, w.spells, search)
+}
+
+func (w mongoTrash) hasSpell(ctx context.Context, id interface{}) (bool, error) {
+	count, err := w.spells.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, storeError(err)
+	}
+
+	return count > 0, nil
+}
+
+// PurgeTrash permanently deletes everything that was put in the trash before
+// the given time
+func (db *DB) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.PurgeTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.PurgeTrash.Before", before.String()))
+
+	collection := db.Database("spellapi").Collection("trash")
+
+	res, err := collection.DeleteMany(ctx, bson.M{"deleted.at": bson.M{"$lt": before}})
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.PurgeTrash.Error", err.Error()))
+		return 0, storeError(err)
+	}
+
+	span.SetAttributes(attribute.Int64("Mongo.PurgeTrash.DeletedCount", res.DeletedCount))
+
+	return res.DeletedCount, nil
+}
+
+// AddRevision stores a revision of a spell. The unique index on spellid and
+// revision turns a reused revision number into ErrRevisionExists.
+func (db *DB) AddRevision(ctx context.Context, revision []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.AddRevision")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("revisions")
+
+	res, err := collection.InsertOne(ctx, revision)
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		span.SetAttributes(attribute.String("Mongo.AddRevision.Error", err.Error()))
+		return ErrRevisionExists
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.AddRevision.Error", err.Error()))
+		return storeError(err)
+	}
+
+	span.SetAttributes(attribute.String("Mongo.AddRevision.Id", fmt.Sprint(res.InsertedID)))
+
+	return nil
+}
+
+// GetRevisions returns every revision of a spell, oldest first
+func (db *DB) GetRevisions(ctx context.Context, spellId primitive.ObjectID) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetRevisions")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.GetRevisions.SpellId", spellId.Hex()))
+
+	collection := db.Database("spellapi").Collection("revisions")
+
+	findOptions := options.Find().SetSort(revisionSort)
+
+	result, err := runQuery(ctx, collection, bson.M{"spellid": spellId}, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetRevisions.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSystems returns the registered game systems matching search, in key
+// order
+func (db *DB) GetSystems(ctx context.Context, search bson.M) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetSystems")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.GetSystems.Query", fmt.Sprintf("%v", search)))
+
+	collection := db.Database("spellapi").Collection("systems")
+
+	findOptions := options.Find().SetSort(systemSort)
+
+	result, err := runQuery(ctx, collection, search, findOptions)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetSystems.Error", err.Error()))
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// AddSystem registers a game system. The unique index on key turns a second
+// system with the same name into ErrSystemAlreadyExists.
+func (db *DB) AddSystem(ctx context.Context, system []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.AddSystem")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("systems")
+
+	err := writeDbObject(ctx, collection, system)
+	if errors.Is(err, ErrSpellAlreadyExists) {
+		span.SetAttributes(attribute.String("Mongo.AddSystem.Error", err.Error()))
+		return ErrSystemAlreadyExists
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.AddSystem.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// UpdateSystem replaces the first system matching search
+func (db *DB) UpdateSystem(ctx context.Context, search bson.M, system []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.UpdateSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.UpdateSystem.Query", fmt.Sprintf("%v", search)))
+
+	collection := db.Database("spellapi").Collection("systems")
+
+	err := replaceDbObject(ctx, collection, search, system)
+	if errors.Is(err, ErrSpellAlreadyExists) {
+		span.SetAttributes(attribute.String("Mongo.UpdateSystem.Error", err.Error()))
+		return ErrSystemAlreadyExists
+	} else if errors.Is(err, ErrSpellNotFound) {
+		span.SetAttributes(attribute.String("Mongo.UpdateSystem.Error", err.Error()))
+		return ErrSystemNotFound
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.UpdateSystem.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// DeleteSystem removes the first system matching search. Spells in the system
+// are left as they are.
+func (db *DB) DeleteSystem(ctx context.Context, search bson.M) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.DeleteSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Mongo.DeleteSystem.Query", fmt.Sprintf("%v", search)))
+
+	collection := db.Database("spellapi").Collection("systems")
+
+	err := deleteDbObject(ctx, collection, search)
+	if errors.Is(err, ErrSpellNotFound) {
+		span.SetAttributes(attribute.String("Mongo.DeleteSystem.Error", err.Error()))
+		return ErrSystemNotFound
+	} else if err != nil {
+		span.SetAttributes(attribute.String("Mongo.DeleteSystem.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// withoutDeleted strips the deletion details from a trashed spell
+func withoutDeleted(doc bson.D) bson.D {
+	spell := bson.D{}
+	for _, e := range doc {
+		if e.Key != "deleted" {
+			spell = append(spell, e)
+		}
+	}
+	return spell
+}
+
+func (db *DB) GetMetadataValues(ctx context.Context, metadataName string) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetMetadataValues")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	values, err := getDistinctValues(ctx, collection, metadataName)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetMetadataValues.Error", err.Error()))
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// GetValueTypes lists the BSON types of the values stored at field in spells
+// matching search. Arrays are unwound so they count as the types of their
+// elements, which is what a query on the field matches against.
+func (db *DB) GetValueTypes(ctx context.Context, field string, search bson.M) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetValueTypes")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Mongo.GetValueTypes.Field", field),
+		attribute.String("Mongo.GetValueTypes.Query", fmt.Sprintf("%v", search)),
+	)
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	match := bson.M{field: bson.M{"$exists": true}}
+	for k, v := range search {
+		match[k] = v
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$project": bson.M{"value": "$" + field}},
+		{"$unwind": "$value"},
+		{"$group": bson.M{"_id": bson.M{"$type": "$value"}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetValueTypes.Error", err.Error()))
+		return nil, storeError(err)
+	}
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetValueTypes.Error", err.Error()))
+		return nil, storeError(err)
+	}
+
+	types := []string{}
+	for _, v := range results {
+		if t, ok := v["_id"].(string); ok {
+			types = append(types, t)
+		}
+	}
+
+	sort.Strings(types)
+	span.SetAttributes(attribute.String("Mongo.GetValueTypes.Types", fmt.Sprint(types)))
+
+	return types, nil
+}
+
+func (db *DB) GetMetadataNames(ctx context.Context) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.GetMetadataNames")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	keysRaw, err := getKeys(ctx, collection)
+	if err != nil {
+		span.SetAttributes(attribute.String("Mongo.GetMetadataNames.Error", err.Error()))
+		return nil, err
+	}
+
+	exist := make(map[string]bool)
+	keys := []string{"system"}
+	for _, v := range keysRaw {
+		if _, ok := exist[v["data"].(string)]; !ok {
+			exist[v["data"].(string)] = true
+			keys = append(keys, v["data"].(string))
 		}
 	}
 
 	span.SetAttributes(attribute.String("Mongo.GetMetadataNames.Keys", fmt.Sprint(keys)))
 	return keys, nil
 }
+
+// FindDuplicateSpells reports every name and system that's used by more than
+// one spell, then every alias shared by more than one spell in a system
+func (db *DB) FindDuplicateSpells(ctx context.Context) ([]DuplicateSpell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Mongo.FindDuplicateSpells")
+	defer span.End()
+
+	collection := db.Database("spellapi").Collection("spells")
+
+	names := []bson.M{
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"name":   "$name",
+					"system": "$metadata.system",
+				},
+				"count": bson.M{"$sum": 1},
+				"ids":   bson.M{"$push": "$_id"},
+			},
+		},
+		{
+			"$match": bson.M{
+				"count": bson.M{"$gt": 1},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":    0,
+				"name":   "$_id.name",
+				"system": "$_id.system",
+				"count":  1,
+				"ids":    1,
+			},
+		},
+	}
+
+	// A spell listing the same alias twice doesn't stop the index being
+	// created, so each spell is only counted once
+	aliases := []bson.M{
+		{
+			"$unwind": "$aliasKeys",
+		},
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"name":   "$aliasKeys",
+					"system": "$metadata.system",
+				},
+				"ids": bson.M{"$addToSet": "$_id"},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":    0,
+				"name":   "$_id.name",
+				"system": "$_id.system",
+				"alias":  bson.M{"$literal": true},
+				"count":  bson.M{"$size": "$ids"},
+				"ids":    1,
+			},
+		},
+		{
+			"$match": bson.M{
+				"count": bson.M{"$gt": 1},
+			},
+		},
+	}
+
+	results := []DuplicateSpell{}
+	for _, query := range [][]bson.M{names, aliases} {
+		cursor, err := collection.Aggregate(ctx, query)
+		if err != nil {
+			span.SetAttributes(attribute.String("Mongo.FindDuplicateSpells.Error", err.Error()))
+			return nil, storeError(err)
+		}
+
+		var duplicates []DuplicateSpell
+		if err = cursor.All(ctx, &duplicates); err != nil {
+			span.SetAttributes(attribute.String("Mongo.FindDuplicateSpells.Error", err.Error()))
+			return nil, storeError(err)
+		}
+
+		results = append(results, duplicates...)
+	}
+	span.SetAttributes(attribute.Int("Mongo.FindDuplicateSpells.Count", len(results)))
+
+	return results, nil
+}
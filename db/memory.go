@@ -0,0 +1,1487 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MemoryDB is an in-memory spell store for local development and tests. It
+// understands the subset of Mongo query operators the API builds.
+type MemoryDB struct {
+	mu     sync.RWMutex
+	spells []bson.Raw
+	trash  []bson.Raw
+
+	revisions []bson.Raw
+	systems   []bson.Raw
+}
+
+// Create an empty in-memory store
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{}
+}
+
+func (m *MemoryDB) GetSpell(ctx context.Context, search bson.M) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.GetSpell.Query", fmt.Sprintf("%v", search)))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []bson.M{}
+	for _, raw := range m.spells {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetSpell.Error", err.Error()))
+			return nil, err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetSpell.Error", err.Error()))
+			return nil, err
+		}
+		if ok {
+			results = append(results, doc)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("Memory.GetSpell.Results.Count", len(results)))
+
+	return results, nil
+}
+
+func (m *MemoryDB) GetSpellPage(ctx context.Context, search bson.M, skip int64, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetSpellPage")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.GetSpellPage.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Memory.GetSpellPage.Skip", skip),
+		attribute.Int64("Memory.GetSpellPage.Limit", limit),
+	)
+
+	results, err := m.GetSpell(ctx, search)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.GetSpellPage.Error", err.Error()))
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return compareSortKeys(results[i], results[j]) < 0
+	})
+
+	if skip >= int64(len(results)) {
+		return []bson.M{}, nil
+	}
+	results = results[skip:]
+
+	if limit > 0 && limit < int64(len(results)) {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (m *MemoryDB) GetSpellNames(ctx context.Context, search bson.M, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetSpellNames")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.GetSpellNames.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Memory.GetSpellNames.Limit", limit),
+	)
+
+	results, err := m.GetSpellPage(ctx, search, 0, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.GetSpellNames.Error", err.Error()))
+		return nil, err
+	}
+
+	names := make([]bson.M, len(results))
+	for i, doc := range results {
+		system, _ := lookupPath(doc, "metadata.system")
+		names[i] = bson.M{
+			"name":     doc["name"],
+			"metadata": bson.M{"system": system},
+		}
+		if displayName, ok := doc["displayName"]; ok {
+			names[i]["displayName"] = displayName
+		}
+	}
+
+	return names, nil
+}
+
+func (m *MemoryDB) CountSpells(ctx context.Context, search bson.M) (int64, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.CountSpells")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.CountSpells.Query", fmt.Sprintf("%v", search)))
+
+	results, err := m.GetSpell(ctx, search)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.CountSpells.Error", err.Error()))
+		return 0, err
+	}
+
+	return int64(len(results)), nil
+}
+
+// SearchSpells ranks spells by how often the search terms appear in them,
+// standing in for a Mongo text index with rankTextMatches. A term matches any word it's a prefix
+// of, so "heal" finds "healing".
+func (m *MemoryDB) SearchSpells(ctx context.Context, text string, search bson.M, limit int64) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.SearchSpells")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.SearchSpells.Text", text),
+		attribute.String("Memory.SearchSpells.Query", fmt.Sprintf("%v", search)),
+		attribute.Int64("Memory.SearchSpells.Limit", limit),
+	)
+
+	results, err := m.GetSpell(ctx, search)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.SearchSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	matches := rankTextMatches(results, text, limit)
+
+	span.SetAttributes(attribute.Int("Memory.SearchSpells.Results.Count", len(matches)))
+
+	return matches, nil
+}
+
+func (m *MemoryDB) AddSpell(ctx context.Context, spell []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.AddSpell")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.insertSpell(ctx, spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.AddSpell.Error", err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) AddSpells(ctx context.Context, spells [][]byte) []error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.AddSpells")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("Memory.AddSpells.Count", len(spells)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror an unordered InsertMany, a spell that fails doesn't stop the rest
+	errs := make([]error, len(spells))
+	failed := 0
+	for i, spell := range spells {
+		errs[i] = m.insertSpell(ctx, spell)
+		if errs[i] != nil {
+			failed++
+		}
+	}
+
+	span.SetAttributes(attribute.Int("Memory.AddSpells.Failed", failed))
+
+	return errs
+}
+
+// SupportsTransactions is always true, as AddSpellsAtomic holds the lock for
+// the whole batch
+func (m *MemoryDB) SupportsTransactions() bool {
+	return true
+}
+
+// AddSpellsAtomic adds every spell or none of them. If any fail the errors say
+// which, and the ones that were added are taken out again before the lock is
+// released.
+func (m *MemoryDB) AddSpellsAtomic(ctx context.Context, spells [][]byte) ([]error, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.AddSpellsAtomic")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("Memory.AddSpellsAtomic.Count", len(spells)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := len(m.spells)
+	errs := make([]error, len(spells))
+	failed := 0
+	for i, spell := range spells {
+		errs[i] = m.insertSpell(ctx, spell)
+		if errs[i] != nil {
+			failed++
+		}
+	}
+
+	span.SetAttributes(attribute.Int("Memory.AddSpellsAtomic.Failed", failed))
+
+	if failed > 0 {
+		m.spells = m.spells[:count]
+	}
+
+	return errs, nil
+}
+
+// insertSpell adds one spell, failing if it clashes with one already stored.
+// The caller must hold the lock.
+func (m *MemoryDB) insertSpell(ctx context.Context, spell []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	_, span := tracer.Start(ctx, "Memory.InsertSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.InsertSpell.Spell", string(spell)))
+
+	raw, id, err := ensureId(spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.InsertSpell.Error", err.Error()))
+		return err
+	}
+
+	exists, err := m.conflicts(raw, -1)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.InsertSpell.Error", err.Error()))
+		return err
+	} else if exists {
+		span.SetAttributes(attribute.String("Memory.InsertSpell.Error", ErrSpellAlreadyExists.Error()))
+		return ErrSpellAlreadyExists
+	}
+
+	m.spells = append(m.spells, raw)
+
+	span.SetAttributes(attribute.String("Memory.InsertSpell.Id", fmt.Sprint(id)))
+
+	return nil
+}
+
+func (m *MemoryDB) UpdateSpell(ctx context.Context, search bson.M, spell []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.UpdateSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.UpdateSpell.Query", fmt.Sprintf("%v", search)),
+		attribute.String("Memory.UpdateSpell.Spell", string(spell)),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror ReplaceOne by only replacing the first match and keeping its _id
+	for i, raw := range m.spells {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", err.Error()))
+			return err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", err.Error()))
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		var replacement bson.D
+		if err := bson.Unmarshal(spell, &replacement); err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", err.Error()))
+			return err
+		}
+
+		fields := bson.D{{Key: "_id", Value: doc["_id"]}}
+		for _, e := range replacement {
+			if e.Key != "_id" {
+				fields = append(fields, e)
+			}
+		}
+
+		updated, err := bson.Marshal(fields)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", err.Error()))
+			return err
+		}
+
+		exists, err := m.conflicts(updated, i)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", err.Error()))
+			return err
+		} else if exists {
+			span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", ErrSpellAlreadyExists.Error()))
+			return ErrSpellAlreadyExists
+		}
+
+		m.spells[i] = updated
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("Memory.UpdateSpell.Error", ErrSpellNotFound.Error()))
+	return ErrSpellNotFound
+}
+
+func (m *MemoryDB) DeleteSpell(ctx context.Context, spell bson.M) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.DeleteSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.DeleteSpell.Spell", fmt.Sprintf("%v", spell)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror DeleteOne by only removing the first match
+	for i, raw := range m.spells {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.DeleteSpell.Error", err.Error()))
+			return err
+		}
+
+		ok, err := matchDocument(doc, spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.DeleteSpell.Error", err.Error()))
+			return err
+		}
+		if ok {
+			m.spells = append(m.spells[:i], m.spells[i+1:]...)
+			span.SetAttributes(attribute.Int("Memory.DeleteSpell.DeletedCount", 1))
+			return nil
+		}
+	}
+
+	span.SetAttributes(attribute.Int("Memory.DeleteSpell.DeletedCount", 0))
+
+	return ErrSpellNotFound
+}
+
+func (m *MemoryDB) TrashSpell(ctx context.Context, search bson.M, deletedBy string, deletedAt time.Time) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.TrashSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.TrashSpell.Query", fmt.Sprintf("%v", search)),
+		attribute.String("Memory.TrashSpell.DeletedBy", deletedBy),
+	)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, raw := range m.spells {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.TrashSpell.Error", err.Error()))
+			return err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.TrashSpell.Error", err.Error()))
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		var spell bson.D
+		if err := bson.Unmarshal(raw, &spell); err != nil {
+			span.SetAttributes(attribute.String("Memory.TrashSpell.Error", err.Error()))
+			return err
+		}
+
+		spell = append(spell, bson.E{Key: "deleted", Value: bson.D{
+			{Key: "at", Value: deletedAt},
+			{Key: "by", Value: deletedBy},
+		}})
+
+		trashed, err := bson.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.TrashSpell.Error", err.Error()))
+			return err
+		}
+
+		m.trash = append(m.trash, trashed)
+		m.spells = append(m.spells[:i], m.spells[i+1:]...)
+
+		span.SetAttributes(attribute.String("Memory.TrashSpell.Id", fmt.Sprint(doc["_id"])))
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("Memory.TrashSpell.Error", ErrSpellNotFound.Error()))
+	return ErrSpellNotFound
+}
+
+func (m *MemoryDB) GetTrash(ctx context.Context, search bson.M) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.GetTrash.Query", fmt.Sprintf("%v", search)))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []bson.M{}
+	for _, raw := range m.trash {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetTrash.Error", err.Error()))
+			return nil, err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetTrash.Error", err.Error()))
+			return nil, err
+		}
+		if ok {
+			results = append(results, doc)
+		}
+	}
+
+	// Most recently deleted first, the same as the Mongo trashSort
+	sort.SliceStable(results, func(i, j int) bool {
+		a, _ := lookupPath(results[i], "deleted.at")
+		b, _ := lookupPath(results[j], "deleted.at")
+		c, _ := compareValues(a, b)
+		return c > 0
+	})
+
+	span.SetAttributes(attribute.Int("Memory.GetTrash.Results.Count", len(results)))
+
+	return results, nil
+}
+
+func (m *MemoryDB) RestoreSpell(ctx context.Context, id primitive.ObjectID) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.RestoreSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.RestoreSpell.Id", id.Hex()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, raw := range m.trash {
+		var deleted bson.D
+		if err := bson.Unmarshal(raw, &deleted); err != nil {
+			span.SetAttributes(attribute.String("Memory.RestoreSpell.Error", err.Error()))
+			return err
+		}
+
+		if deleted.Map()["_id"] != id {
+			continue
+		}
+
+		spell, err := bson.Marshal(withoutDeleted(deleted))
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.RestoreSpell.Error", err.Error()))
+			return err
+		}
+
+		exists, err := m.conflicts(spell, -1)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.RestoreSpell.Error", err.Error()))
+			return err
+		} else if exists {
+			span.SetAttributes(attribute.String("Memory.RestoreSpell.Error", ErrSpellAlreadyExists.Error()))
+			return ErrSpellAlreadyExists
+		}
+
+		m.spells = append(m.spells, spell)
+		m.trash = append(m.trash[:i], m.trash[i+1:]...)
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("Memory.RestoreSpell.Error", ErrSpellNotFound.Error()))
+	return ErrSpellNotFound
+}
+
+func (m *MemoryDB) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.PurgeTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.PurgeTrash.Before", before.String()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := []bson.Raw{}
+	for _, raw := range m.trash {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.PurgeTrash.Error", err.Error()))
+			return 0, err
+		}
+
+		deletedAt, _ := lookupPath(doc, "deleted.at")
+		if c, ok := compareValues(deletedAt, before); !ok || c >= 0 {
+			kept = append(kept, raw)
+		}
+	}
+
+	purged := int64(len(m.trash) - len(kept))
+	m.trash = kept
+
+	span.SetAttributes(attribute.Int64("Memory.PurgeTrash.DeletedCount", purged))
+
+	return purged, nil
+}
+
+func (m *MemoryDB) AddRevision(ctx context.Context, revision []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.AddRevision")
+	defer span.End()
+
+	raw, id, err := ensureId(revision)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.AddRevision.Error", err.Error()))
+		return err
+	}
+
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.AddRevision.Error", err.Error()))
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror the unique index on spellid and revision
+	for _, other := range m.revisions {
+		otherDoc, err := decodeDocument(other)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.AddRevision.Error", err.Error()))
+			return err
+		}
+
+		if otherDoc["spellid"] == doc["spellid"] && matchEqual(otherDoc["revision"], doc["revision"]) {
+			span.SetAttributes(attribute.String("Memory.AddRevision.Error", ErrRevisionExists.Error()))
+			return ErrRevisionExists
+		}
+	}
+
+	m.revisions = append(m.revisions, raw)
+
+	span.SetAttributes(attribute.String("Memory.AddRevision.Id", fmt.Sprint(id)))
+
+	return nil
+}
+
+func (m *MemoryDB) GetRevisions(ctx context.Context, spellId primitive.ObjectID) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetRevisions")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.GetRevisions.SpellId", spellId.Hex()))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []bson.M{}
+	for _, raw := range m.revisions {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetRevisions.Error", err.Error()))
+			return nil, err
+		}
+
+		if doc["spellid"] == spellId {
+			results = append(results, doc)
+		}
+	}
+
+	// Oldest first, the same as the Mongo revisionSort
+	sort.SliceStable(results, func(i, j int) bool {
+		c, _ := compareValues(results[i]["revision"], results[j]["revision"])
+		return c < 0
+	})
+
+	span.SetAttributes(attribute.Int("Memory.GetRevisions.Results.Count", len(results)))
+
+	return results, nil
+}
+
+func (m *MemoryDB) GetSystems(ctx context.Context, search bson.M) ([]bson.M, error) {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetSystems")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.GetSystems.Query", fmt.Sprintf("%v", search)))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []bson.M{}
+	for _, raw := range m.systems {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetSystems.Error", err.Error()))
+			return nil, err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetSystems.Error", err.Error()))
+			return nil, err
+		}
+		if ok {
+			results = append(results, doc)
+		}
+	}
+
+	// Key order, the same as the Mongo systemSort
+	sort.SliceStable(results, func(i, j int) bool {
+		c, _ := compareValues(results[i]["key"], results[j]["key"])
+		return c < 0
+	})
+
+	span.SetAttributes(attribute.Int("Memory.GetSystems.Results.Count", len(results)))
+
+	return results, nil
+}
+
+func (m *MemoryDB) AddSystem(ctx context.Context, system []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.AddSystem")
+	defer span.End()
+
+	raw, id, err := ensureId(system)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.AddSystem.Error", err.Error()))
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists, err := m.systemConflicts(raw, -1)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.AddSystem.Error", err.Error()))
+		return err
+	} else if exists {
+		span.SetAttributes(attribute.String("Memory.AddSystem.Error", ErrSystemAlreadyExists.Error()))
+		return ErrSystemAlreadyExists
+	}
+
+	m.systems = append(m.systems, raw)
+
+	span.SetAttributes(attribute.String("Memory.AddSystem.Id", fmt.Sprint(id)))
+
+	return nil
+}
+
+func (m *MemoryDB) UpdateSystem(ctx context.Context, search bson.M, system []byte) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.UpdateSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.UpdateSystem.Query", fmt.Sprintf("%v", search)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror ReplaceOne by only replacing the first match and keeping its _id
+	for i, raw := range m.systems {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", err.Error()))
+			return err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", err.Error()))
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		var replacement bson.D
+		if err := bson.Unmarshal(system, &replacement); err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", err.Error()))
+			return err
+		}
+
+		fields := bson.D{{Key: "_id", Value: doc["_id"]}}
+		for _, e := range replacement {
+			if e.Key != "_id" {
+				fields = append(fields, e)
+			}
+		}
+
+		updated, err := bson.Marshal(fields)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", err.Error()))
+			return err
+		}
+
+		exists, err := m.systemConflicts(updated, i)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", err.Error()))
+			return err
+		} else if exists {
+			span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", ErrSystemAlreadyExists.Error()))
+			return ErrSystemAlreadyExists
+		}
+
+		m.systems[i] = updated
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("Memory.UpdateSystem.Error", ErrSystemNotFound.Error()))
+	return ErrSystemNotFound
+}
+
+func (m *MemoryDB) DeleteSystem(ctx context.Context, search bson.M) error {
+
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.DeleteSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.DeleteSystem.Query", fmt.Sprintf("%v", search)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mirror DeleteOne by only removing the first match
+	for i, raw := range m.systems {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.DeleteSystem.Error", err.Error()))
+			return err
+		}
+
+		ok, err := matchDocument(doc, search)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.DeleteSystem.Error", err.Error()))
+			return err
+		}
+		if ok {
+			m.systems = append(m.systems[:i], m.systems[i+1:]...)
+			return nil
+		}
+	}
+
+	span.SetAttributes(attribute.String("Memory.DeleteSystem.Error", ErrSystemNotFound.Error()))
+	return ErrSystemNotFound
+}
+
+func (m *MemoryDB) GetMetadataValues(ctx context.Context, metadataName string) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetMetadataValues")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("Memory.GetMetadataValues.MetadataName", metadataName))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exist := make(map[string]bool)
+	values := []string{}
+	for _, raw := range m.spells {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetMetadataValues.Error", err.Error()))
+			return nil, err
+		}
+
+		value, ok := lookupPath(doc, metadataName)
+		if !ok {
+			continue
+		}
+
+		// Distinct flattens arrays into their individual elements
+		for _, v := range expandArray(value) {
+			s := fmt.Sprintf("%v", v)
+			if !exist[s] {
+				exist[s] = true
+				values = append(values, s)
+			}
+		}
+	}
+
+	sort.Strings(values)
+
+	return values, nil
+}
+
+// GetValueTypes lists the BSON types of the values stored at field in spells
+// matching search, with arrays counted by the types of their elements, the
+// same as the $type aggregation in Mongo
+func (m *MemoryDB) GetValueTypes(ctx context.Context, field string, search bson.M) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetValueTypes")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("Memory.GetValueTypes.Field", field),
+		attribute.String("Memory.GetValueTypes.Query", fmt.Sprintf("%v", search)),
+	)
+
+	results, err := m.GetSpell(ctx, search)
+	if err != nil {
+		span.SetAttributes(attribute.String("Memory.GetValueTypes.Error", err.Error()))
+		return nil, err
+	}
+
+	exist := make(map[string]bool)
+	types := []string{}
+	for _, doc := range results {
+		value, ok := lookupPath(doc, field)
+		if !ok {
+			continue
+		}
+
+		for _, v := range expandArray(value) {
+			t := bsonTypeName(v)
+			if !exist[t] {
+				exist[t] = true
+				types = append(types, t)
+			}
+		}
+	}
+
+	sort.Strings(types)
+	span.SetAttributes(attribute.String("Memory.GetValueTypes.Types", fmt.Sprint(types)))
+
+	return types, nil
+}
+
+// bsonTypeName is the name $type gives the type of a decoded value
+func bsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64:
+		return "double"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case primitive.Decimal128:
+		return "decimal"
+	case primitive.DateTime:
+		return "date"
+	case primitive.ObjectID:
+		return "objectId"
+	}
+
+	if _, ok := toDocument(v); ok {
+		return "object"
+	} else if _, ok := toList(v); ok {
+		return "array"
+	}
+
+	return "unknown"
+}
+
+func (m *MemoryDB) GetMetadataNames(ctx context.Context) ([]string, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "Memory.GetMetadataNames")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exist := make(map[string]bool)
+	keys := []string{"system"}
+	for _, raw := range m.spells {
+		spellData, err := raw.LookupErr("spelldata")
+		if err != nil {
+			continue
+		}
+
+		doc, ok := spellData.DocumentOK()
+		if !ok {
+			continue
+		}
+
+		elements, err := doc.Elements()
+		if err != nil {
+			span.SetAttributes(attribute.String("Memory.GetMetadataNames.Error", err.Error()))
+			return nil, err
+		}
+
+		for _, e := range elements {
+			if !exist[e.Key()] {
+				exist[e.Key()] = true
+				keys = append(keys, e.Key())
+			}
+		}
+	}
+
+	span.SetAttributes(attribute.String("Memory.GetMetadataNames.Keys", fmt.Sprint(keys)))
+	return keys, nil
+}
+
+// rankTextMatches scores docs by how often the words in text appear in the
+// fields in textWeights, the same ones the Mongo text index covers, and
+// returns the best limit of those that match at all. Ties are broken by name,
+// system and _id.
+func rankTextMatches(docs []bson.M, text string, limit int64) []bson.M {
+	terms := textTokens(text)
+	matches := []bson.M{}
+	for _, doc := range docs {
+		score := 0.0
+		for field, weight := range textWeights {
+			score += float64(weight) * countTermMatches(terms, doc[field])
+		}
+
+		if score > 0 {
+			doc["score"] = score
+			matches = append(matches, doc)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		si, sj := matches[i]["score"].(float64), matches[j]["score"].(float64)
+		if si != sj {
+			return si > sj
+		}
+		return compareSortKeys(matches[i], matches[j]) < 0
+	})
+
+	if limit > 0 && limit < int64(len(matches)) {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
+
+// countTermMatches counts the words in every string inside value that start
+// with one of the search terms.
+func countTermMatches(terms []string, value interface{}) float64 {
+	count := 0.0
+	switch v := value.(type) {
+	case string:
+		for _, word := range textTokens(v) {
+			for _, term := range terms {
+				if strings.HasPrefix(word, term) {
+					count++
+				}
+			}
+		}
+	default:
+		if d, ok := toDocument(v); ok {
+			for _, child := range d {
+				count += countTermMatches(terms, child)
+			}
+		} else if list, ok := toList(v); ok {
+			for _, child := range list {
+				count += countTermMatches(terms, child)
+			}
+		}
+	}
+	return count
+}
+
+func textTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// compareSortKeys orders spells by name, system and then _id, matching the
+// sort used for paging in Mongo.
+func compareSortKeys(a, b bson.M) int {
+	for _, path := range []string{"name", "metadata.system", "_id"} {
+		av, _ := lookupPath(a, path)
+		bv, _ := lookupPath(b, path)
+		if c, ok := compareValues(av, bv); ok && c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// conflicts reports whether another spell already has the same name, or
+// shares an alias, in the same system, mirroring the unique indexes on the
+// Mongo collection. The caller must hold the lock.
+func (m *MemoryDB) conflicts(raw bson.Raw, skip int) (bool, error) {
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return false, err
+	}
+
+	name, _ := lookupPath(doc, "name")
+	system, _ := lookupPath(doc, "metadata.system")
+	aliases, _ := lookupPath(doc, "aliasKeys")
+
+	for i, other := range m.spells {
+		if i == skip {
+			continue
+		}
+
+		otherDoc, err := decodeDocument(other)
+		if err != nil {
+			return false, err
+		}
+
+		otherName, _ := lookupPath(otherDoc, "name")
+		otherSystem, _ := lookupPath(otherDoc, "metadata.system")
+		if otherSystem != system {
+			continue
+		}
+		if otherName == name {
+			return true, nil
+		}
+
+		otherAliases, _ := lookupPath(otherDoc, "aliasKeys")
+		for _, a := range expandArray(aliases) {
+			if _, ok := a.(string); !ok {
+				continue
+			}
+			for _, o := range expandArray(otherAliases) {
+				if a == o {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// systemConflicts reports whether another system already has the same key,
+// mirroring the unique index on the Mongo collection. The caller must hold the
+// lock.
+func (m *MemoryDB) systemConflicts(raw bson.Raw, skip int) (bool, error) {
+	doc, err := decodeDocument(raw)
+	if err != nil {
+		return false, err
+	}
+
+	for i, other := range m.systems {
+		if i == skip {
+			continue
+		}
+
+		otherDoc, err := decodeDocument(other)
+		if err != nil {
+			return false, err
+		}
+
+		if otherDoc["key"] == doc["key"] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ensureId gives a document an ObjectID the way the Mongo driver does on
+// insert, keeping the order of the remaining fields intact.
+func ensureId(raw []byte) (bson.Raw, interface{}, error) {
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	for _, e := range doc {
+		if e.Key == "_id" {
+			return raw, e.Value, nil
+		}
+	}
+
+	id := primitive.NewObjectID()
+	doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+
+	withId, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return withId, id, nil
+}
+
+func decodeDocument(raw []byte) (bson.M, error) {
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// matchDocument reports whether doc satisfies a Mongo style filter.
+func matchDocument(doc bson.M, filter bson.M) (bool, error) {
+	for key, condition := range filter {
+		var ok bool
+		var err error
+
+		switch key {
+		case "$and":
+			ok, err = matchLogical(doc, condition, true)
+		case "$or":
+			ok, err = matchLogical(doc, condition, false)
+		default:
+			value, exists := lookupPath(doc, key)
+			ok, err = matchCondition(value, exists, condition)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(doc bson.M, condition interface{}, all bool) (bool, error) {
+	var filters []bson.M
+	switch c := condition.(type) {
+	case []bson.M:
+		filters = c
+	case []interface{}:
+		for _, f := range c {
+			m, ok := toDocument(f)
+			if !ok {
+				return false, fmt.Errorf("unsupported logical filter: %v", f)
+			}
+			filters = append(filters, m)
+		}
+	case primitive.A:
+		return matchLogical(doc, []interface{}(c), all)
+	default:
+		return false, fmt.Errorf("unsupported logical filter: %v", condition)
+	}
+
+	for _, f := range filters {
+		ok, err := matchDocument(doc, f)
+		if err != nil {
+			return false, err
+		}
+		if ok && !all {
+			return true, nil
+		} else if !ok && all {
+			return false, nil
+		}
+	}
+
+	return all, nil
+}
+
+func matchCondition(value interface{}, exists bool, condition interface{}) (bool, error) {
+	operators, ok := toDocument(condition)
+	if !ok || !isOperatorDocument(operators) {
+		return exists && matchEqual(value, condition), nil
+	}
+
+	for op, operand := range operators {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = exists && matchEqual(value, operand)
+		case "$ne":
+			ok = !exists || !matchEqual(value, operand)
+		case "$in", "$nin":
+			list, isList := toList(operand)
+			if !isList {
+				return false, fmt.Errorf("%s requires an array", op)
+			}
+			found := false
+			for _, v := range list {
+				if exists && matchEqual(value, v) {
+					found = true
+					break
+				}
+			}
+			ok = found == (op == "$in")
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = exists && matchRange(value, op, operand)
+		case "$exists":
+			want, isBool := operand.(bool)
+			if !isBool {
+				return false, fmt.Errorf("$exists requires a boolean")
+			}
+			ok = exists == want
+		case "$regex":
+			options, _ := operators["$options"].(string)
+			matched, err := matchRegex(value, operand, options)
+			if err != nil {
+				return false, err
+			}
+			ok = exists && matched
+		case "$options":
+			ok = true
+		default:
+			return false, fmt.Errorf("unsupported query operator: %s", op)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func matchEqual(value interface{}, want interface{}) bool {
+	for _, v := range append([]interface{}{value}, arrayElements(value)...) {
+		if c, ok := compareValues(v, want); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRange(value interface{}, op string, operand interface{}) bool {
+	for _, v := range expandArray(value) {
+		c, ok := compareValues(v, operand)
+		if !ok {
+			continue
+		}
+		switch {
+		case op == "$gt" && c > 0,
+			op == "$gte" && c >= 0,
+			op == "$lt" && c < 0,
+			op == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegex(value interface{}, pattern interface{}, options string) (bool, error) {
+	var expr string
+	switch p := pattern.(type) {
+	case string:
+		expr = p
+	case primitive.Regex:
+		expr = p.Pattern
+		options += p.Options
+	default:
+		return false, fmt.Errorf("$regex requires a string")
+	}
+
+	if strings.Contains(options, "i") {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range expandArray(value) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareValues orders two BSON values of a compatible type, treating all
+// numeric types as equivalent the way Mongo does.
+func compareValues(a, b interface{}) (int, bool) {
+	if af, ok := toFloat(a); ok {
+		bf, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	case bool:
+		bv, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if av == bv {
+			return 0, true
+		} else if !av {
+			return -1, true
+		}
+		return 1, true
+	case primitive.ObjectID:
+		bv, ok := b.(primitive.ObjectID)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av.Hex(), bv.Hex()), true
+	case primitive.DateTime:
+		return compareTimes(av.Time(), b)
+	case time.Time:
+		return compareTimes(av, b)
+	case nil:
+		return 0, b == nil
+	}
+
+	return 0, false
+}
+
+func compareTimes(a time.Time, b interface{}) (int, bool) {
+	var bt time.Time
+	switch bv := b.(type) {
+	case primitive.DateTime:
+		bt = bv.Time()
+	case time.Time:
+		bt = bv
+	default:
+		return 0, false
+	}
+
+	switch {
+	case a.Before(bt):
+		return -1, true
+	case a.After(bt):
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toDocument(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return bson.M(d), true
+	case bson.D:
+		return d.Map(), true
+	}
+	return nil, false
+}
+
+func toList(v interface{}) ([]interface{}, bool) {
+	switch l := v.(type) {
+	case []interface{}:
+		return l, true
+	case primitive.A:
+		return l, true
+	case []string:
+		list := make([]interface{}, len(l))
+		for i, s := range l {
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func isOperatorDocument(d bson.M) bool {
+	if len(d) == 0 {
+		return false
+	}
+	for k := range d {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayElements(v interface{}) []interface{} {
+	if list, ok := toList(v); ok {
+		return list
+	}
+	return nil
+}
+
+func expandArray(v interface{}) []interface{} {
+	if list, ok := toList(v); ok {
+		return list
+	}
+	return []interface{}{v}
+}
+
+// lookupPath resolves a dotted field path such as spelldata.level.
+func lookupPath(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		d, ok := toDocument(current)
+		if !ok {
+			return nil, false
+		}
+		current, ok = d[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,242 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/chrislgardner/spellapi/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newTestMemoryDB(t *testing.T) *db.MemoryDB {
+	t.Helper()
+
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	spells := []bson.M{
+		{"name": "fireball", "description": "Big boom", "spelldata": bson.D{{Key: "level", Value: 3}, {Key: "school", Value: "evocation"}}, "metadata": bson.M{"system": "test1"}},
+		{"name": "fireball", "description": "Bigger boom", "spelldata": bson.D{{Key: "level", Value: "5"}, {Key: "tags", Value: []string{"fire", "aoe"}}}, "metadata": bson.M{"system": "test2"}},
+		{"name": "cure wounds", "description": "Heals", "spelldata": bson.D{{Key: "level", Value: 1}, {Key: "school", Value: "evocation"}}, "metadata": bson.M{"system": "test1"}},
+	}
+
+	for _, s := range spells {
+		raw, err := bson.Marshal(s)
+		if err != nil {
+			t.Fatalf("Marshal() err = %v; want nil", err)
+		}
+		if err := store.AddSpell(ctx, raw); err != nil {
+			t.Fatalf("AddSpell() err = %v; want nil", err)
+		}
+	}
+
+	return store
+}
+
+func TestMemoryDB_GetSpell(t *testing.T) {
+	store := newTestMemoryDB(t)
+
+	testCases := []struct {
+		name  string
+		query bson.M
+		want  int
+	}{
+		{"empty filter", bson.M{}, 3},
+		{"eq name", bson.M{"name": bson.M{"$eq": "fireball"}}, 2},
+		{"eq system", bson.M{"name": bson.M{"$eq": "fireball"}, "metadata.system": bson.M{"$eq": "test1"}}, 1},
+		{"in spelldata", bson.M{"spelldata.school": bson.M{"$in": []string{"evocation"}}}, 2},
+		{"in array field", bson.M{"spelldata.tags": bson.M{"$in": []string{"aoe"}}}, 1},
+		{"numeric type", bson.M{"spelldata.level": bson.M{"$in": []interface{}{int64(3)}}}, 1},
+		{"string not number", bson.M{"spelldata.level": bson.M{"$in": []string{"3"}}}, 0},
+		{"missing path", bson.M{"spelldata.range": bson.M{"$in": []string{"60ft"}}}, 0},
+	}
+
+	for _, tc := range testCases {
+		got, err := store.GetSpell(context.Background(), tc.query)
+		if err != nil {
+			t.Fatalf("%s: GetSpell() err = %v; want nil", tc.name, err)
+		}
+		if len(got) != tc.want {
+			t.Errorf("%s: GetSpell() returned %d results; want %d", tc.name, len(got), tc.want)
+		}
+	}
+}
+
+func TestMemoryDB_DeleteSpell(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	err := store.DeleteSpell(ctx, bson.M{"name": bson.M{"$eq": "fireball"}, "metadata.system": bson.M{"$eq": "test2"}})
+	if err != nil {
+		t.Fatalf("DeleteSpell() err = %v; want nil", err)
+	}
+
+	got, err := store.GetSpell(ctx, bson.M{"name": bson.M{"$eq": "fireball"}})
+	if err != nil {
+		t.Fatalf("GetSpell() err = %v; want nil", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("GetSpell() returned %d results; want 1", len(got))
+	}
+	if got[0]["description"] != "Big boom" {
+		t.Errorf("DeleteSpell() removed the wrong spell, %v remains", got[0])
+	}
+
+	err = store.DeleteSpell(ctx, bson.M{"name": bson.M{"$eq": "fireball"}, "metadata.system": bson.M{"$eq": "test2"}})
+	if !errors.Is(err, db.ErrSpellNotFound) {
+		t.Errorf("DeleteSpell() err = %v; want %v", err, db.ErrSpellNotFound)
+	}
+}
+
+func TestMemoryDB_Metadata(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	systems, err := store.GetMetadataValues(ctx, "metadata.system")
+	if err != nil {
+		t.Fatalf("GetMetadataValues() err = %v; want nil", err)
+	}
+	if want := []string{"test1", "test2"}; !reflect.DeepEqual(systems, want) {
+		t.Errorf("GetMetadataValues() = %v; want %v", systems, want)
+	}
+
+	levels, err := store.GetMetadataValues(ctx, "spelldata.tags")
+	if err != nil {
+		t.Fatalf("GetMetadataValues() err = %v; want nil", err)
+	}
+	if want := []string{"aoe", "fire"}; !reflect.DeepEqual(levels, want) {
+		t.Errorf("GetMetadataValues() = %v; want %v", levels, want)
+	}
+
+	names, err := store.GetMetadataNames(ctx)
+	if err != nil {
+		t.Fatalf("GetMetadataNames() err = %v; want nil", err)
+	}
+	if want := []string{"system", "level", "school", "tags"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GetMetadataNames() = %v; want %v", names, want)
+	}
+}
+
+func TestMemoryDB_Unique(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	raw, _ := bson.Marshal(bson.M{"name": "fireball", "description": "Again", "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != db.ErrSpellAlreadyExists {
+		t.Errorf("AddSpell() err = %v; want %v", err, db.ErrSpellAlreadyExists)
+	}
+
+	raw, _ = bson.Marshal(bson.M{"name": "fireball", "description": "Renamed", "metadata": bson.M{"system": "test1"}})
+	err := store.UpdateSpell(ctx, bson.M{"name": bson.M{"$eq": "cure wounds"}}, raw)
+	if err != db.ErrSpellAlreadyExists {
+		t.Errorf("UpdateSpell() err = %v; want %v", err, db.ErrSpellAlreadyExists)
+	}
+
+	// Aliases are unique within a system, spells without any don't conflict
+	raw, _ = bson.Marshal(bson.M{"name": "shield", "aliasKeys": bson.A{"barrier", "ward"}, "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Errorf("AddSpell(shield) err = %v; want nil", err)
+	}
+	raw, _ = bson.Marshal(bson.M{"name": "mage armour", "aliasKeys": bson.A{"ward"}, "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != db.ErrSpellAlreadyExists {
+		t.Errorf("AddSpell(mage armour) err = %v; want %v", err, db.ErrSpellAlreadyExists)
+	}
+	raw, _ = bson.Marshal(bson.M{"name": "mage armour", "aliasKeys": bson.A{"ward"}, "metadata": bson.M{"system": "test2"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Errorf("AddSpell(mage armour) in another system err = %v; want nil", err)
+	}
+}
+
+func TestMemoryDB_AddSpells(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	spells := []bson.M{
+		{"name": "shield", "description": "Block", "metadata": bson.M{"system": "test1"}},
+		{"name": "fireball", "description": "Again", "metadata": bson.M{"system": "test1"}},
+		{"name": "shield", "description": "Block again", "metadata": bson.M{"system": "test1"}},
+		{"name": "shield", "description": "Block", "metadata": bson.M{"system": "test2"}},
+	}
+	raw := make([][]byte, len(spells))
+	for i, s := range spells {
+		raw[i], _ = bson.Marshal(s)
+	}
+
+	errs := store.AddSpells(ctx, raw)
+	want := []error{nil, db.ErrSpellAlreadyExists, db.ErrSpellAlreadyExists, nil}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("AddSpells() errs = %v; want %v", errs, want)
+	}
+
+	got, err := store.GetSpell(ctx, bson.M{"name": bson.M{"$eq": "shield"}})
+	if err != nil || len(got) != 2 {
+		t.Errorf("GetSpell(shield) = %d spells, %v; want 2", len(got), err)
+	}
+}
+
+func TestMemoryDB_AddSpellsAtomic(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	marshal := func(spells ...bson.M) [][]byte {
+		raw := make([][]byte, len(spells))
+		for i, s := range spells {
+			raw[i], _ = bson.Marshal(s)
+		}
+		return raw
+	}
+
+	errs, err := store.AddSpellsAtomic(ctx, marshal(
+		bson.M{"name": "shield", "description": "Block", "metadata": bson.M{"system": "test1"}},
+		bson.M{"name": "fireball", "description": "Again", "metadata": bson.M{"system": "test1"}},
+	))
+	want := []error{nil, db.ErrSpellAlreadyExists}
+	if err != nil || !reflect.DeepEqual(errs, want) {
+		t.Errorf("AddSpellsAtomic() = %v, %v; want %v", errs, err, want)
+	}
+
+	got, err := store.GetSpell(ctx, bson.M{"name": bson.M{"$eq": "shield"}})
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetSpell(shield) = %d spells, %v; want none added", len(got), err)
+	}
+
+	errs, err = store.AddSpellsAtomic(ctx, marshal(
+		bson.M{"name": "shield", "description": "Block", "metadata": bson.M{"system": "test1"}},
+		bson.M{"name": "shield", "description": "Block", "metadata": bson.M{"system": "test2"}},
+	))
+	if err != nil || !reflect.DeepEqual(errs, []error{nil, nil}) {
+		t.Errorf("AddSpellsAtomic() = %v, %v; want both added", errs, err)
+	}
+
+	got, err = store.GetSpell(ctx, bson.M{"name": bson.M{"$eq": "shield"}})
+	if err != nil || len(got) != 2 {
+		t.Errorf("GetSpell(shield) = %d spells, %v; want 2", len(got), err)
+	}
+}
+
+func TestMemoryDB_GetValueTypes(t *testing.T) {
+	store := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		field  string
+		search bson.M
+		want   []string
+	}{
+		{"spelldata.level", bson.M{}, []string{"int", "string"}},
+		{"spelldata.level", bson.M{"metadata.system": bson.M{"$eq": "test1"}}, []string{"int"}},
+		{"spelldata.tags", bson.M{}, []string{"string"}},
+		{"spelldata.range", bson.M{}, []string{}},
+	}
+
+	for _, v := range testCases {
+		got, err := store.GetValueTypes(ctx, v.field, v.search)
+		if err != nil {
+			t.Fatalf("GetValueTypes(%s) err = %v; want nil", v.field, err)
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("GetValueTypes(%s, %v) = %v; want %v", v.field, v.search, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// fakeTrash keeps spells and trash copies by _id and fails the writes named
+// in fail, standing in for mongo without transactions
+type fakeTrash struct {
+	spells map[interface{}]bson.D
+	trash  map[interface{}]bson.D
+	fail   map[string]bool
+}
+
+func newFakeTrash(spells ...bson.D) *fakeTrash {
+	w := &fakeTrash{
+		spells: map[interface{}]bson.D{},
+		trash:  map[interface{}]bson.D{},
+		fail:   map[string]bool{},
+	}
+	for _, spell := range spells {
+		w.spells[spell.Map()["_id"]] = spell
+	}
+	return w
+}
+
+func (w *fakeTrash) saveTrash(ctx context.Context, id interface{}, spell bson.D) error {
+	if w.fail["saveTrash"] {
+		return errWriteFailed
+	}
+	w.trash[id] = spell
+	return nil
+}
+
+func (w *fakeTrash) deleteTrash(ctx context.Context, id interface{}) error {
+	if w.fail["deleteTrash"] {
+		return errWriteFailed
+	}
+	if _, ok := w.trash[id]; !ok {
+		return ErrSpellNotFound
+	}
+	delete(w.trash, id)
+	return nil
+}
+
+func (w *fakeTrash) insertSpell(ctx context.Context, spell bson.D) error {
+	if w.fail["insertSpell"] {
+		return errWriteFailed
+	}
+	doc := spell.Map()
+	for id, existing := range w.spells {
+		if id == doc["_id"] || existing.Map()["name"] == doc["name"] {
+			return ErrSpellAlreadyExists
+		}
+	}
+	w.spells[doc["_id"]] = spell
+	return nil
+}
+
+func (w *fakeTrash) deleteSpell(ctx context.Context, search bson.M) error {
+	if w.fail["deleteSpell"] {
+		return errWriteFailed
+	}
+	if _, ok := w.spells[search["_id"]]; !ok {
+		return ErrSpellNotFound
+	}
+	delete(w.spells, search["_id"])
+	return nil
+}
+
+func (w *fakeTrash) hasSpell(ctx context.Context, id interface{}) (bool, error) {
+	_, ok := w.spells[id]
+	return ok, nil
+}
+
+func (w *fakeTrash) check(t *testing.T, id interface{}, inSpells bool, inTrash bool) {
+	t.Helper()
+
+	if _, ok := w.spells[id]; ok != inSpells {
+		t.Errorf("spell in spells = %v, want %v", ok, inSpells)
+	}
+	if _, ok := w.trash[id]; ok != inTrash {
+		t.Errorf("spell in trash = %v, want %v", ok, inTrash)
+	}
+}
+
+func TestTrashDocument_FailedWrites(t *testing.T) {
+	ctx := context.Background()
+	spell := bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "fireball"}}
+	search := bson.M{"_id": 1}
+
+	w := newFakeTrash(spell)
+	w.fail["deleteSpell"] = true
+	err := trashDocument(ctx, w, 1, spell, search)
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("trashDocument() error = %v, want %v", err, errWriteFailed)
+	}
+	w.check(t, 1, true, false)
+
+	w.fail["deleteSpell"] = false
+	err = trashDocument(ctx, w, 1, spell, search)
+	if err != nil {
+		t.Fatalf("trashDocument() retry error = %v", err)
+	}
+	w.check(t, 1, false, true)
+
+	// With the copy left behind as well, either trashing or restoring again
+	// tidies up
+	w = newFakeTrash(spell)
+	w.fail["deleteSpell"] = true
+	w.fail["deleteTrash"] = true
+	trashDocument(ctx, w, 1, spell, search)
+	w.check(t, 1, true, true)
+
+	w.fail = map[string]bool{}
+	err = trashDocument(ctx, w, 1, spell, search)
+	if err != nil {
+		t.Fatalf("trashDocument() retry error = %v", err)
+	}
+	w.check(t, 1, false, true)
+
+	w = newFakeTrash(spell)
+	w.trash[1] = spell
+	err = restoreDocument(ctx, w, 1, spell)
+	if err != nil {
+		t.Fatalf("restoreDocument() error = %v", err)
+	}
+	w.check(t, 1, true, false)
+}
+
+func TestRestoreDocument_FailedWrites(t *testing.T) {
+	ctx := context.Background()
+	spell := bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "fireball"}}
+
+	w := newFakeTrash()
+	w.trash[1] = spell
+	w.fail["insertSpell"] = true
+	err := restoreDocument(ctx, w, 1, spell)
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("restoreDocument() error = %v, want %v", err, errWriteFailed)
+	}
+	w.check(t, 1, false, true)
+
+	w.fail = map[string]bool{"deleteTrash": true}
+	err = restoreDocument(ctx, w, 1, spell)
+	if !errors.Is(err, errWriteFailed) {
+		t.Fatalf("restoreDocument() error = %v, want %v", err, errWriteFailed)
+	}
+	w.check(t, 1, true, true)
+
+	w.fail = map[string]bool{}
+	err = restoreDocument(ctx, w, 1, spell)
+	if err != nil {
+		t.Fatalf("restoreDocument() retry error = %v", err)
+	}
+	w.check(t, 1, true, false)
+
+	// A spell that's taken the name keeps the trash copy
+	reused := bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "fireball"}}
+	w = newFakeTrash(reused)
+	w.trash[1] = spell
+	err = restoreDocument(ctx, w, 1, spell)
+	if !errors.Is(err, ErrSpellAlreadyExists) {
+		t.Fatalf("restoreDocument() error = %v, want %v", err, ErrSpellAlreadyExists)
+	}
+	w.check(t, 1, false, true)
+
+	delete(w.spells, 2)
+	err = restoreDocument(ctx, w, 1, spell)
+	if err != nil {
+		t.Fatalf("restoreDocument() after rename error = %v", err)
+	}
+	w.check(t, 1, true, false)
+}
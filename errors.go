@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chrislgardner/spellapi/db"
+)
+
+// Errors returned by the spell functions, wrapped with more detail where it
+// helps so check for them with errors.Is
+var (
+	// ErrNotFound is returned when no spell matches
+	ErrNotFound = db.ErrSpellNotFound
+	// ErrConflict is returned when a spell with the same name already exists
+	// in the system
+	ErrConflict = db.ErrSpellAlreadyExists
+	// ErrAmbiguousMatch is returned when more than one spell matches where
+	// only one is expected, usually because the system wasn't given
+	ErrAmbiguousMatch = errors.New(MultipleMatchingSpells)
+	// ErrValidation is matched by every ValidationError
+	ErrValidation = errors.New("validation failed")
+	// ErrStoreUnavailable is returned when the database can't be reached
+	ErrStoreUnavailable = db.ErrUnavailable
+	// ErrRevisionExists is returned when a revision number is taken by a
+	// concurrent edit
+	ErrRevisionExists = db.ErrRevisionExists
+	// ErrPreconditionFailed is returned when a spell has changed since the
+	// version the caller expected in If-Match
+	ErrPreconditionFailed = errors.New("spell has changed since it was read")
+	// ErrPreconditionRequired is returned when a change is made without
+	// saying which version of the spell it's based on
+	ErrPreconditionRequired = errors.New("If-Match header is required")
+	// ErrSystemNotFound is returned when no registered system has the name
+	ErrSystemNotFound = db.ErrSystemNotFound
+	// ErrSystemConflict is returned when a system's name or alias is already
+	// used by another system
+	ErrSystemConflict = db.ErrSystemAlreadyExists
+	// ErrRequestTooLarge is returned when a request body is over the
+	// configured limit
+	ErrRequestTooLarge = errors.New("request body is too large")
+	// ErrBatchAborted is returned for spells in an atomic batch that weren't
+	// added because another spell in the batch failed
+	ErrBatchAborted = errors.New("batch aborted")
+	// ErrTransactionsUnsupported is returned for an atomic batch when the
+	// database can't write it all at once
+	ErrTransactionsUnsupported = db.ErrTransactionsUnsupported
+)
+
+// ValidationError is a problem with a spell or request sent by a client.
+// Field names the part of the input that's wrong, when there is one.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ValidationErrors is every problem found with a spell at once, such as each
+// spelldata field that doesn't match its system's schema
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func newValidationError(field string, format string, a ...interface{}) error {
+	return &ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// statusFromError maps an error from the spell functions to the HTTP status
+// handlers respond with
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, ErrValidation), errors.Is(err, ErrAmbiguousMatch):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrSystemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrSystemConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, ErrRequestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrPreconditionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, ErrTransactionsUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrStoreUnavailable):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ETag identifies this version of the spell. It changes every time the spell
+// is updated and is never reused by another spell.
+func (s Spell) ETag() string {
+	return fmt.Sprintf(`"%s-%d"`, s.ID.Hex(), s.Version)
+}
+
+// checkIfMatch compares the If-Match value sent with a change against the
+// spell as it is now. An empty ifMatch skips the check, "*" matches any
+// version.
+func checkIfMatch(existing Spell, ifMatch string) error {
+	if ifMatch == "" || etagMatches(ifMatch, existing.ETag(), false) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: current version is %s", ErrPreconditionFailed, existing.ETag())
+}
+
+// etagMatches reports whether any of the comma separated tags in header
+// match etag. Weak tags only count when weak is set, as If-None-Match allows
+// but If-Match doesn't.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// versionFilter matches a spell only while it's still at the version that was
+// read, so a change made in between is never overwritten. Spells from before
+// versions were tracked don't have the field at all.
+func versionFilter(existing Spell) bson.M {
+	filter := bson.M{"_id": existing.ID}
+	if existing.Version == 0 {
+		filter["version"] = bson.M{"$exists": false}
+	} else {
+		filter["version"] = existing.Version
+	}
+
+	return filter
+}
+
+// ifMatchHeader returns the If-Match header for a change, failing when it's
+// missing so that edits can't silently overwrite each other
+func ifMatchHeader(r *http.Request) (string, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return "", ErrPreconditionRequired
+	}
+
+	return ifMatch, nil
+}
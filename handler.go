@@ -2,17 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"mime"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 )
 
+const (
+	defaultPageLimit   = 100
+	maxPageLimit       = 1000
+	defaultSearchLimit = 25
+	maxSearchLimit     = 100
+
+	defaultSuggestionLimit = 5
+	maxSuggestionLimit     = 25
+
+	defaultAutocompleteLimit = 25
+	maxAutocompleteLimit     = 100
+)
+
 func (s *SpellService) GetSpellHandler(w http.ResponseWriter, r *http.Request) {
 	tracer := otel.Tracer("Encantus")
 	ctx, span := tracer.Start(r.Context(), "GetSpellHandler")
@@ -28,29 +44,173 @@ func (s *SpellService) GetSpellHandler(w http.ResponseWriter, r *http.Request) {
 	)
 
 	spell, err := FindSpell(ctx, s.store, spellName, query)
-	if err != nil && err.Error() == MultipleMatchingSpells {
-		span.SetAttributes(attribute.String("GetSpellHandler.Error", "MultipleMatchingSpells"))
-		http.Error(w, MultipleMatchingSpells, http.StatusBadRequest)
-		return
-	} else if err != nil {
+	if err != nil {
 		span.SetAttributes(attribute.String("GetSpellHandler.Error", err.Error()))
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
-			http.StatusInternalServerError)
+		httpError(ctx, w, err)
 		return
 	}
 
 	if spell.Name == "" {
 		span.SetAttributes(attribute.String("GetSpellHandler.Error", "NotFound"))
-		http.Error(w, http.StatusText(http.StatusNotFound),
-			http.StatusNotFound)
+
+		// Most misses are typos so offer the closest names in the same system
+		suggestionQuery := url.Values{}
+		if system := query.Get("system"); system != "" {
+			suggestionQuery.Set("system", system)
+		}
+
+		suggestions, err := SuggestSpells(ctx, s.store, spellName, suggestionQuery, defaultSuggestionLimit)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellHandler.SuggestionError", err.Error()))
+			suggestions = []SpellSuggestion{}
+		}
+
+		resp := SpellNotFoundResponse{
+			Problem:     problemFromError(ctx, ErrNotFound),
+			Suggestions: suggestions,
+		}
+
+		writeProblem(w, http.StatusNotFound, resp)
+		return
+	}
+
+	etag := spell.ETag()
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		span.SetAttributes(attribute.Bool("GetSpellHandler.NotModified", true))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	json, err := json.Marshal(spell)
 	if err != nil {
 		span.SetAttributes(attribute.String("GetSpellHandler.Error", err.Error()))
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
-			http.StatusInternalServerError)
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) GetSpellByIdHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSpellByIdHandler")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	spellId := vars["id"]
+
+	span.SetAttributes(attribute.String("GetSpellByIdHandler.Id", spellId))
+
+	spell, err := FindSpellById(ctx, s.store, spellId)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellByIdHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	if spell.Name == "" {
+		span.SetAttributes(attribute.String("GetSpellByIdHandler.Error", "NotFound"))
+		httpError(ctx, w, ErrNotFound)
+		return
+	}
+
+	etag := spell.ETag()
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		span.SetAttributes(attribute.Bool("GetSpellByIdHandler.NotModified", true))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	json, err := json.Marshal(spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellByIdHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) GetSpellSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSpellSuggestionsHandler")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	spellName := vars["name"]
+	query := r.URL.Query()
+	limitParam := query.Get("limit")
+	query.Del("limit")
+
+	span.SetAttributes(
+		attribute.String("GetSpellSuggestionsHandler.SpellName", spellName),
+		attribute.String("GetSpellSuggestionsHandler.Query", query.Encode()),
+	)
+
+	limit := defaultSuggestionLimit
+	if limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed < 1 || parsed > maxSuggestionLimit {
+			span.SetAttributes(attribute.String("GetSpellSuggestionsHandler.Error", "InvalidLimit"))
+			httpError(ctx, w, newValidationError("limit", "limit must be between 1 and %d", maxSuggestionLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	suggestions, err := SuggestSpells(ctx, s.store, spellName, query, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellSuggestionsHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(suggestions)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellSuggestionsHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) AutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "AutocompleteHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	system := query.Get("system")
+	limitParam := query.Get("limit")
+
+	span.SetAttributes(
+		attribute.String("AutocompleteHandler.Prefix", prefix),
+		attribute.String("AutocompleteHandler.System", system),
+	)
+
+	limit := int64(defaultAutocompleteLimit)
+	if limitParam != "" {
+		parsed, err := strconv.ParseInt(limitParam, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxAutocompleteLimit {
+			span.SetAttributes(attribute.String("AutocompleteHandler.Error", "InvalidLimit"))
+			httpError(ctx, w, newValidationError("limit", "limit must be between 1 and %d", maxAutocompleteLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	names, err := AutocompleteSpells(ctx, s.store, prefix, system, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("AutocompleteHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(names)
+	if err != nil {
+		span.SetAttributes(attribute.String("AutocompleteHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
 		return
 	}
 	fmt.Fprint(w, string(json))
@@ -61,23 +221,25 @@ func (s *SpellService) PostSpellHandler(w http.ResponseWriter, r *http.Request)
 	ctx, span := tracer.Start(r.Context(), "PostSpellHandler")
 	defer span.End()
 
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := s.readBody(r)
 	if err != nil {
 		span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-		http.Error(w, http.StatusText(http.StatusBadRequest),
-			http.StatusBadRequest)
+		httpError(ctx, w, err)
 		return
 	}
 
-	if multipostEnabled := s.flags.GetBoolFlag(ctx, "multipost-spell", s.flags.GetUser(ctx, r)); multipostEnabled {
+	strict := strictHandling(w, r)
+	span.SetAttributes(attribute.Bool("PostSpellHandler.Strict", strict))
+
+	user := s.flags.GetUser(ctx, r)
+	if multipostEnabled := s.flags.GetBoolFlag(ctx, "multipost-spell", user); multipostEnabled {
 		span.SetAttributes(attribute.Bool("PostSpellHandler.Multipost.Flag", multipostEnabled))
 
 		var incomingRequest Request
 		err = json.NewDecoder(bytes.NewReader(body)).Decode(&incomingRequest)
 		if err != nil {
 			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			httpError(ctx, w, newValidationError("", "invalid request: %v", err))
 			return
 		}
 
@@ -86,38 +248,28 @@ func (s *SpellService) PostSpellHandler(w http.ResponseWriter, r *http.Request)
 		resp.Data = []ErrorResponse{}
 		errorOccured := false
 
+		data := make([]json.RawMessage, 0, len(incomingRequest.Data))
 		for _, d := range incomingRequest.Data {
-
 			temp, err := json.Marshal(d)
 			if err != nil {
 				resp.Data = append(resp.Data, ErrorResponse{err.Error(), http.StatusBadRequest})
 				errorOccured = true
 				continue
 			}
+			data = append(data, temp)
+		}
 
-			spell, err := ParseSpell(ctx, temp)
-			if err != nil && strings.Contains(err.Error(), "missing required") {
-				resp.Data = append(resp.Data, ErrorResponse{err.Error(), http.StatusBadRequest})
-				errorOccured = true
-				continue
-
-			} else if err != nil {
-				resp.Data = append(resp.Data, ErrorResponse{err.Error(), http.StatusInternalServerError})
-				errorOccured = true
-				continue
-			}
-
-			span.SetAttributes(attribute.Stringer("PostSpellHandler.Parsed", spell))
+		items, err := AddSpellBatch(ctx, s.store, data, false, strict, user.GetKey())
+		if err != nil {
+			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
 
-			err = AddSpell(ctx, s.store, spell)
-			if err != nil && err.Error() == SpellAlreadyExists {
-				resp.Data = append(resp.Data, ErrorResponse{err.Error(), http.StatusConflict})
-				errorOccured = true
-				continue
-			} else if err != nil {
-				resp.Data = append(resp.Data, ErrorResponse{err.Error(), http.StatusInternalServerError})
+		for _, item := range items {
+			if item.Err != nil {
+				resp.Data = append(resp.Data, ErrorResponse{item.Err.Error(), statusFromError(item.Err)})
 				errorOccured = true
-				continue
 			}
 		}
 
@@ -138,8 +290,7 @@ func (s *SpellService) PostSpellHandler(w http.ResponseWriter, r *http.Request)
 		responseBytes, err := json.Marshal(resp)
 		if err != nil {
 			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			httpStatusError(ctx, w, http.StatusInternalServerError)
 			return
 		}
 
@@ -149,165 +300,986 @@ func (s *SpellService) PostSpellHandler(w http.ResponseWriter, r *http.Request)
 
 		span.SetAttributes(attribute.String("PostSpellHandler.Raw", string(body)))
 
-		spell, err := ParseSpell(ctx, body)
-		if err != nil && strings.Contains(err.Error(), "missing required") {
-			span.SetAttributes(attribute.String("PostSpellHandler.Error", "MissingRequiredField"))
-			resp := fmt.Sprintf("%v: %v", http.StatusText(http.StatusBadRequest), err.Error())
-			http.Error(w, resp,
-				http.StatusBadRequest)
-			return
-		} else if err != nil {
+		if strict {
+			if err := checkSpellFields(body); err != nil {
+				span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
+				httpError(ctx, w, err)
+				return
+			}
+		}
+
+		spell, err := ParseSpell(ctx, s.store, body)
+		if err != nil {
 			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			httpError(ctx, w, err)
 			return
 		}
 
 		span.SetAttributes(attribute.Stringer("PostSpellHandler.Parsed", spell))
 
-		err = AddSpell(ctx, s.store, spell)
-		if err != nil && err.Error() == SpellAlreadyExists {
-			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-			http.Error(w, SpellAlreadyExists,
-				http.StatusConflict)
-			return
-		} else if err != nil {
+		spell, err = AddSpell(ctx, s.store, spell, user.GetKey())
+		if err != nil {
 			span.SetAttributes(attribute.String("PostSpellHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			httpError(ctx, w, err)
 			return
 		}
 
+		w.Header().Set("Location", fmt.Sprintf("/spells/id/%s", spell.ID.Hex()))
+		w.Header().Set("ETag", spell.ETag())
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprint(w, "Spell added")
 	}
 }
 
-func (s *SpellService) DeleteSpellHandler(w http.ResponseWriter, r *http.Request) {
+func (s *SpellService) BatchSpellHandler(w http.ResponseWriter, r *http.Request) {
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(r.Context(), "DeleteSpellHandler")
+	ctx, span := tracer.Start(r.Context(), "BatchSpellHandler")
 	defer span.End()
 
-	if deleteEnabled := s.flags.GetBoolFlag(ctx, "delete-spell", s.flags.GetUser(ctx, r)); deleteEnabled {
-		span.SetAttributes(attribute.Bool("DeleteSpellHandler.Flag", deleteEnabled))
-		vars := mux.Vars(r)
-		spellName := vars["name"]
-		query := r.URL.Query()
+	user := s.flags.GetUser(ctx, r)
+
+	// rollbackOnError is the old name for atomic
+	atomic := false
+	for _, param := range []string{"atomic", "rollbackOnError"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
 
-		err := DeleteSpell(ctx, s.store, spellName, query)
+		parsed, err := strconv.ParseBool(value)
 		if err != nil {
-			span.SetAttributes(attribute.String("DeleteSpellHandler.Error", "NotFound"))
-			http.Error(w, http.StatusText(http.StatusNotFound),
-				http.StatusNotFound)
+			span.SetAttributes(attribute.String("BatchSpellHandler.Error", err.Error()))
+			httpError(ctx, w, newValidationError(param, "%s must be true or false", param))
 			return
 		}
-		w.WriteHeader(http.StatusAccepted)
-		fmt.Fprint(w, "Spell Removed")
+		atomic = parsed
+		break
+	}
 
-	} else {
-		span.SetAttributes(attribute.Bool("DeleteSpellHandler.Flag", deleteEnabled))
-		http.Error(w, http.StatusText(http.StatusForbidden),
-			http.StatusForbidden)
+	body, err := s.readBody(r)
+	if err != nil {
+		span.SetAttributes(attribute.String("BatchSpellHandler.Error", err.Error()))
+		httpError(ctx, w, err)
 		return
 	}
 
-}
-
-func (s *SpellService) GetAllSpellHandler(w http.ResponseWriter, r *http.Request) {
-	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(r.Context(), "GetAllSpellHandler")
-	defer span.End()
+	strict := strictHandling(w, r)
+	span.SetAttributes(
+		attribute.Bool("BatchSpellHandler.Atomic", atomic),
+		attribute.Bool("BatchSpellHandler.Strict", strict),
+	)
 
-	query := r.URL.Query()
+	var batch BatchRequest
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		span.SetAttributes(attribute.String("BatchSpellHandler.Error", err.Error()))
+		httpError(ctx, w, newValidationError("", "invalid batch: %v", err))
+		return
+	}
 
-	span.SetAttributes(attribute.String("GetAllSpellHandler.Query", query.Encode()))
+	if len(batch.Data) == 0 {
+		span.SetAttributes(attribute.String("BatchSpellHandler.Error", "EmptyBatch"))
+		httpError(ctx, w, newValidationError("data", "missing required field: data"))
+		return
+	}
 
-	spells, err := GetAllSpell(ctx, s.store, query)
+	items, err := AddSpellBatch(ctx, s.store, batch.Data, atomic, strict, user.GetKey())
 	if err != nil {
-		span.SetAttributes(attribute.String("GetAllSpellHandler.Error", "NotFound"))
-		http.Error(w, http.StatusText(http.StatusNotFound),
-			http.StatusNotFound)
+		span.SetAttributes(attribute.String("BatchSpellHandler.Error", err.Error()))
+		httpError(ctx, w, err)
 		return
 	}
-	json, err := json.Marshal(spells)
+
+	resp := BatchResponse{
+		Count:   len(items),
+		Atomic:  atomic,
+		Results: make([]BatchResult, len(items)),
+	}
+	failedStatuses := map[int]bool{}
+	for i, item := range items {
+		result := BatchResult{
+			Index:  item.Index,
+			Name:   item.Name,
+			Status: http.StatusCreated,
+		}
+
+		if item.Err != nil {
+			problem := problemFromError(ctx, item.Err)
+			result.Status = problem.Status
+			result.Error = &problem
+			failedStatuses[problem.Status] = true
+			resp.Failed++
+		} else {
+			result.ID = item.Spell.ID.Hex()
+			resp.Created++
+		}
+
+		resp.Results[i] = result
+	}
+
+	span.SetAttributes(
+		attribute.Int("BatchSpellHandler.Created", resp.Created),
+		attribute.Int("BatchSpellHandler.Failed", resp.Failed),
+	)
+
+	// 207 whenever the spells had different outcomes, otherwise the one
+	// status they all share
+	status := http.StatusMultiStatus
+	if resp.Failed == 0 {
+		status = http.StatusCreated
+	} else if resp.Created == 0 && len(failedStatuses) == 1 {
+		status = resp.Results[0].Status
+	}
+
+	responseBytes, err := json.Marshal(resp)
 	if err != nil {
-		span.SetAttributes(attribute.String("GetAllSpellHandler.Error", err.Error()))
-		http.Error(w, http.StatusText(http.StatusInternalServerError),
-			http.StatusInternalServerError)
+		span.SetAttributes(attribute.String("BatchSpellHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
 		return
 	}
-	fmt.Fprint(w, string(json))
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseBytes)
 }
 
-func (s *SpellService) GetSpellMetadataHandler(w http.ResponseWriter, r *http.Request) {
+func (s *SpellService) PutSpellHandler(w http.ResponseWriter, r *http.Request) {
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(r.Context(), "GetSpellMetadataHandler")
+	ctx, span := tracer.Start(r.Context(), "PutSpellHandler")
 	defer span.End()
 
-	if metadataEnabled := s.flags.GetBoolFlag(ctx, "get-spell-metadata", s.flags.GetUser(ctx, r)); metadataEnabled {
-		span.SetAttributes(attribute.Bool("GetSpellMetadataHandler.Flag", metadataEnabled))
+	user := s.flags.GetUser(ctx, r)
+	if updateEnabled := s.flags.GetBoolFlag(ctx, "update-spell", user); updateEnabled {
+		span.SetAttributes(attribute.Bool("PutSpellHandler.Flag", updateEnabled))
 
 		vars := mux.Vars(r)
-		metadataName := vars["name"]
+		spellName := vars["name"]
+		spellId, byId := vars["id"]
+		query := r.URL.Query()
 
-		span.SetAttributes(attribute.String("GetSpellMetadataHandler.MetadataName", metadataName))
+		span.SetAttributes(
+			attribute.String("PutSpellHandler.SpellName", spellName),
+			attribute.String("PutSpellHandler.Id", spellId),
+			attribute.String("PutSpellHandler.Query", query.Encode()),
+		)
 
-		metadata, err := GetSpellMetadata(ctx, s.store, metadataName)
+		ifMatch, err := ifMatchHeader(r)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetSpellMetadataHandler.Error", "NotFound"))
-			http.Error(w, http.StatusText(http.StatusNotFound),
-				http.StatusNotFound)
+			span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
 			return
 		}
-		json, err := json.Marshal(metadata)
+
+		body, err := s.readBody(r)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetSpellMetadataHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
 			return
 		}
 
-		fmt.Fprintf(w, "{\"%s\":%s}", metadataName, string(json))
+		strict := strictHandling(w, r)
+		span.SetAttributes(attribute.Bool("PutSpellHandler.Strict", strict))
+
+		span.SetAttributes(attribute.String("PutSpellHandler.Raw", string(body)))
+
+		if strict {
+			if err := checkSpellFields(body); err != nil {
+				span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+				httpError(ctx, w, err)
+				return
+			}
+		}
+
+		spell, err := ParseSpell(ctx, s.store, body)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		span.SetAttributes(attribute.Stringer("PutSpellHandler.Parsed", spell))
+
+		if byId {
+			spell, err = ReplaceSpellById(ctx, s.store, spellId, spell, ifMatch, user.GetKey())
+		} else {
+			spell, err = ReplaceSpell(ctx, s.store, spellName, query, spell, ifMatch, user.GetKey())
+		}
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSpellHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", spell.ETag())
+		fmt.Fprint(w, string(json))
+
 	} else {
-		span.SetAttributes(attribute.Bool("GetSpellMetadataHandler.Flag", metadataEnabled))
-		http.Error(w, http.StatusText(http.StatusForbidden),
-			http.StatusForbidden)
+		span.SetAttributes(attribute.Bool("PutSpellHandler.Flag", updateEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
 		return
 	}
 }
 
-func (s *SpellService) GetAllSpellMetadataHandler(w http.ResponseWriter, r *http.Request) {
+func (s *SpellService) PatchSpellHandler(w http.ResponseWriter, r *http.Request) {
 	tracer := otel.Tracer("Encantus")
-	ctx, span := tracer.Start(r.Context(), "GetAllSpellMetadataHandler")
+	ctx, span := tracer.Start(r.Context(), "PatchSpellHandler")
 	defer span.End()
 
-	if metadataEnabled := s.flags.GetBoolFlag(ctx, "get-spell-metadata-names", s.flags.GetUser(ctx, r)); metadataEnabled {
-		span.SetAttributes(attribute.Bool("GetAllSpellMetadataHandler.Flag", metadataEnabled))
+	user := s.flags.GetUser(ctx, r)
+	if updateEnabled := s.flags.GetBoolFlag(ctx, "update-spell", user); updateEnabled {
+		span.SetAttributes(attribute.Bool("PatchSpellHandler.Flag", updateEnabled))
 
+		vars := mux.Vars(r)
+		spellName := vars["name"]
+		spellId, byId := vars["id"]
 		query := r.URL.Query()
 
-		span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Query", query.Encode()))
+		span.SetAttributes(
+			attribute.String("PatchSpellHandler.SpellName", spellName),
+			attribute.String("PatchSpellHandler.Id", spellId),
+			attribute.String("PatchSpellHandler.Query", query.Encode()),
+		)
 
-		metadata, err := GetAllSpellMetadata(ctx, s.store, query)
+		contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (contentType != MergePatchContentType && contentType != JSONPatchContentType) {
+			span.SetAttributes(attribute.String("PatchSpellHandler.Error", "UnsupportedMediaType"))
+			w.Header().Set("Accept-Patch", fmt.Sprintf("%s, %s", MergePatchContentType, JSONPatchContentType))
+			httpStatusError(ctx, w, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		ifMatch, err := ifMatchHeader(r)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Error", "NotFound"))
-			http.Error(w, http.StatusText(http.StatusNotFound),
-				http.StatusNotFound)
+			span.SetAttributes(attribute.String("PatchSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
 			return
 		}
-		json, err := json.Marshal(metadata)
+
+		body, err := s.readBody(r)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Error", err.Error()))
-			http.Error(w, http.StatusText(http.StatusInternalServerError),
-				http.StatusInternalServerError)
+			span.SetAttributes(attribute.String("PatchSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		strict := strictHandling(w, r)
+		span.SetAttributes(attribute.Bool("PatchSpellHandler.Strict", strict))
+
+		span.SetAttributes(attribute.String("PatchSpellHandler.Raw", string(body)))
+
+		if strict {
+			if err := checkPatchFields(body, contentType); err != nil {
+				span.SetAttributes(attribute.String("PatchSpellHandler.Error", err.Error()))
+				httpError(ctx, w, err)
+				return
+			}
+		}
+
+		var spell Spell
+		if byId {
+			spell, err = PatchSpellById(ctx, s.store, spellId, body, contentType, ifMatch, user.GetKey())
+		} else {
+			spell, err = PatchSpell(ctx, s.store, spellName, query, body, contentType, ifMatch, user.GetKey())
+		}
+		if err != nil {
+			span.SetAttributes(attribute.String("PatchSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("PatchSpellHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("ETag", spell.ETag())
 		fmt.Fprint(w, string(json))
+
 	} else {
-		span.SetAttributes(attribute.Bool("GetAllSpellMetadataHandler.Flag", metadataEnabled))
-		http.Error(w, http.StatusText(http.StatusForbidden),
-			http.StatusForbidden)
+		span.SetAttributes(attribute.Bool("PatchSpellHandler.Flag", updateEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) DeleteSpellHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "DeleteSpellHandler")
+	defer span.End()
+
+	user := s.flags.GetUser(ctx, r)
+	if deleteEnabled := s.flags.GetBoolFlag(ctx, "delete-spell", user); deleteEnabled {
+		span.SetAttributes(attribute.Bool("DeleteSpellHandler.Flag", deleteEnabled))
+		vars := mux.Vars(r)
+		spellName := vars["name"]
+		spellId, byId := vars["id"]
+		query := r.URL.Query()
+		dryRunParam := query.Get("dryRun")
+		query.Del("dryRun")
+
+		span.SetAttributes(
+			attribute.String("DeleteSpellHandler.SpellName", spellName),
+			attribute.String("DeleteSpellHandler.Id", spellId),
+			attribute.String("DeleteSpellHandler.Query", query.Encode()),
+		)
+
+		dryRun := false
+		if dryRunParam != "" {
+			parsed, err := strconv.ParseBool(dryRunParam)
+			if err != nil {
+				span.SetAttributes(attribute.String("DeleteSpellHandler.Error", "InvalidDryRun"))
+				httpError(ctx, w, newValidationError("dryRun", "dryRun must be true or false"))
+				return
+			}
+			dryRun = parsed
+		}
+
+		// A dry run doesn't change anything so only checks If-Match when
+		// it's given
+		ifMatch := r.Header.Get("If-Match")
+		if !dryRun {
+			var err error
+			ifMatch, err = ifMatchHeader(r)
+			if err != nil {
+				span.SetAttributes(attribute.String("DeleteSpellHandler.Error", err.Error()))
+				httpError(ctx, w, err)
+				return
+			}
+		}
+
+		var spell Spell
+		var err error
+		if byId {
+			spell, err = DeleteSpellById(ctx, s.store, spellId, ifMatch, user.GetKey(), dryRun)
+		} else {
+			spell, err = DeleteSpell(ctx, s.store, spellName, query, ifMatch, user.GetKey(), dryRun)
+		}
+		if errors.Is(err, ErrAmbiguousMatch) {
+			// Deleting has to pick a single spell, so more than one
+			// matching conflicts with the request rather than being a
+			// bad lookup
+			span.SetAttributes(attribute.String("DeleteSpellHandler.Error", err.Error()))
+			p := problemFromError(ctx, err)
+			p.Status = http.StatusConflict
+			p.Title = http.StatusText(http.StatusConflict)
+			writeProblem(w, p.Status, p)
+			return
+		} else if err != nil {
+			span.SetAttributes(attribute.String("DeleteSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		if !dryRun {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		json, err := json.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("DeleteSpellHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", spell.ETag())
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("DeleteSpellHandler.Flag", deleteEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+
+}
+
+func (s *SpellService) GetTrashHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetTrashHandler")
+	defer span.End()
+
+	if deleteEnabled := s.flags.GetBoolFlag(ctx, "delete-spell", s.flags.GetUser(ctx, r)); deleteEnabled {
+		span.SetAttributes(attribute.Bool("GetTrashHandler.Flag", deleteEnabled))
+
+		query := r.URL.Query()
+
+		span.SetAttributes(attribute.String("GetTrashHandler.Query", query.Encode()))
+
+		trashed, err := GetTrash(ctx, s.store, query)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetTrashHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(trashed)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetTrashHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("GetTrashHandler.Flag", deleteEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) RestoreSpellHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "RestoreSpellHandler")
+	defer span.End()
+
+	user := s.flags.GetUser(ctx, r)
+	if deleteEnabled := s.flags.GetBoolFlag(ctx, "delete-spell", user); deleteEnabled {
+		span.SetAttributes(attribute.Bool("RestoreSpellHandler.Flag", deleteEnabled))
+
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		span.SetAttributes(attribute.String("RestoreSpellHandler.Id", id))
+
+		spell, err := RestoreSpell(ctx, s.store, id, user.GetKey())
+		if err != nil {
+			span.SetAttributes(attribute.String("RestoreSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("RestoreSpellHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("RestoreSpellHandler.Flag", deleteEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) GetSpellRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSpellRevisionsHandler")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	spellName := vars["name"]
+	query := r.URL.Query()
+
+	span.SetAttributes(
+		attribute.String("GetSpellRevisionsHandler.SpellName", spellName),
+		attribute.String("GetSpellRevisionsHandler.Query", query.Encode()),
+	)
+
+	revisions, err := GetSpellRevisions(ctx, s.store, spellName, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisionsHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(revisions)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisionsHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) GetSpellRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSpellRevisionHandler")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	spellName := vars["name"]
+	query := r.URL.Query()
+
+	span.SetAttributes(
+		attribute.String("GetSpellRevisionHandler.SpellName", spellName),
+		attribute.String("GetSpellRevisionHandler.Revision", vars["n"]),
+		attribute.String("GetSpellRevisionHandler.Query", query.Encode()),
+	)
+
+	number, err := parseRevisionNumber(vars["n"])
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisionHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	revision, err := GetSpellRevision(ctx, s.store, spellName, query, number)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisionHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(revision)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisionHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) RollbackSpellHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "RollbackSpellHandler")
+	defer span.End()
+
+	user := s.flags.GetUser(ctx, r)
+	if updateEnabled := s.flags.GetBoolFlag(ctx, "update-spell", user); updateEnabled {
+		span.SetAttributes(attribute.Bool("RollbackSpellHandler.Flag", updateEnabled))
+
+		vars := mux.Vars(r)
+		spellName := vars["name"]
+		query := r.URL.Query()
+
+		span.SetAttributes(
+			attribute.String("RollbackSpellHandler.SpellName", spellName),
+			attribute.String("RollbackSpellHandler.Revision", vars["n"]),
+			attribute.String("RollbackSpellHandler.Query", query.Encode()),
+		)
+
+		number, err := parseRevisionNumber(vars["n"])
+		if err != nil {
+			span.SetAttributes(attribute.String("RollbackSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		ifMatch, err := ifMatchHeader(r)
+		if err != nil {
+			span.SetAttributes(attribute.String("RollbackSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		spell, err := RollbackSpell(ctx, s.store, spellName, query, number, ifMatch, user.GetKey())
+		if err != nil {
+			span.SetAttributes(attribute.String("RollbackSpellHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("RollbackSpellHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", spell.ETag())
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("RollbackSpellHandler.Flag", updateEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func parseRevisionNumber(n string) (int64, error) {
+	number, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || number < 1 {
+		return 0, newValidationError("n", "revision must be a positive number")
+	}
+
+	return number, nil
+}
+
+func (s *SpellService) GetAllSpellHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetAllSpellHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+
+	span.SetAttributes(attribute.String("GetAllSpellHandler.Query", query.Encode()))
+
+	if query.Get("limit") != "" || query.Get("cursor") != "" || query.Get("count") != "" {
+		s.getSpellPage(ctx, w, r)
+		return
+	}
+
+	spells, err := GetAllSpell(ctx, s.store, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetAllSpellHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+	json, err := json.Marshal(spells)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetAllSpellHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+
+}
+
+// getSpellPage responds to GetAllSpellHandler with a page of spells and a link
+// to the next page when asked for with limit, cursor or count.
+func (s *SpellService) getSpellPage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetSpellPageHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+	limitParam := query.Get("limit")
+	countParam := query.Get("count")
+	query.Del("cursor")
+	query.Del("limit")
+	query.Del("count")
+
+	span.SetAttributes(
+		attribute.String("GetSpellPageHandler.Cursor", cursor),
+		attribute.String("GetSpellPageHandler.Limit", limitParam),
+		attribute.String("GetSpellPageHandler.Count", countParam),
+	)
+
+	limit := int64(defaultPageLimit)
+	if limitParam != "" {
+		parsed, err := strconv.ParseInt(limitParam, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxPageLimit {
+			span.SetAttributes(attribute.String("GetSpellPageHandler.Error", "InvalidLimit"))
+			httpError(ctx, w, newValidationError("limit", "limit must be between 1 and %d", maxPageLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	withTotal := false
+	if countParam != "" {
+		parsed, err := strconv.ParseBool(countParam)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellPageHandler.Error", "InvalidCount"))
+			httpError(ctx, w, newValidationError("count", "count must be true or false"))
+			return
+		}
+		withTotal = parsed
+	}
+
+	page, err := GetSpellPage(ctx, s.store, query, limit, cursor, withTotal)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellPageHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	if page.Cursor != "" {
+		next := *r.URL
+		nextQuery := r.URL.Query()
+		nextQuery.Set("cursor", page.Cursor)
+		nextQuery.Set("limit", strconv.FormatInt(limit, 10))
+		next.RawQuery = nextQuery.Encode()
+		page.Next = next.RequestURI()
+	}
+
+	json, err := json.Marshal(page)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellPageHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "SearchHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+	text := query.Get("q")
+	limitParam := query.Get("limit")
+	query.Del("q")
+	query.Del("limit")
+
+	span.SetAttributes(
+		attribute.String("SearchHandler.Text", text),
+		attribute.String("SearchHandler.Query", query.Encode()),
+	)
+
+	limit := int64(defaultSearchLimit)
+	if limitParam != "" {
+		parsed, err := strconv.ParseInt(limitParam, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			span.SetAttributes(attribute.String("SearchHandler.Error", "InvalidLimit"))
+			httpError(ctx, w, newValidationError("limit", "limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := SearchSpells(ctx, s.store, text, query, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("SearchHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("SearchHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) GetSpellMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSpellMetadataHandler")
+	defer span.End()
+
+	if metadataEnabled := s.flags.GetBoolFlag(ctx, "get-spell-metadata", s.flags.GetUser(ctx, r)); metadataEnabled {
+		span.SetAttributes(attribute.Bool("GetSpellMetadataHandler.Flag", metadataEnabled))
+
+		vars := mux.Vars(r)
+		metadataName := vars["name"]
+
+		span.SetAttributes(attribute.String("GetSpellMetadataHandler.MetadataName", metadataName))
+
+		metadata, err := GetSpellMetadata(ctx, s.store, metadataName)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellMetadataHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+		json, err := json.Marshal(metadata)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellMetadataHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintf(w, "{\"%s\":%s}", metadataName, string(json))
+	} else {
+		span.SetAttributes(attribute.Bool("GetSpellMetadataHandler.Flag", metadataEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) GetAllSpellMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetAllSpellMetadataHandler")
+	defer span.End()
+
+	if metadataEnabled := s.flags.GetBoolFlag(ctx, "get-spell-metadata-names", s.flags.GetUser(ctx, r)); metadataEnabled {
+		span.SetAttributes(attribute.Bool("GetAllSpellMetadataHandler.Flag", metadataEnabled))
+
+		query := r.URL.Query()
+
+		span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Query", query.Encode()))
+
+		metadata, err := GetAllSpellMetadata(ctx, s.store, query)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+		json, err := json.Marshal(metadata)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetAllSpellMetadataHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, string(json))
+	} else {
+		span.SetAttributes(attribute.Bool("GetAllSpellMetadataHandler.Flag", metadataEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) GetSystemsHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSystemsHandler")
+	defer span.End()
+
+	systems, err := GetSystems(ctx, s.store)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystemsHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(systems)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystemsHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) GetSystemHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "GetSystemHandler")
+	defer span.End()
+
+	vars := mux.Vars(r)
+	systemName := vars["name"]
+
+	span.SetAttributes(attribute.String("GetSystemHandler.SystemName", systemName))
+
+	system, err := FindSystem(ctx, s.store, systemName)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystemHandler.Error", err.Error()))
+		httpError(ctx, w, err)
+		return
+	}
+
+	json, err := json.Marshal(system)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystemHandler.Error", err.Error()))
+		httpStatusError(ctx, w, http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(json))
+}
+
+func (s *SpellService) PostSystemHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "PostSystemHandler")
+	defer span.End()
+
+	if systemsEnabled := s.flags.GetBoolFlag(ctx, "manage-systems", s.flags.GetUser(ctx, r)); systemsEnabled {
+		span.SetAttributes(attribute.Bool("PostSystemHandler.Flag", systemsEnabled))
+
+		body, err := s.readBody(r)
+		if err != nil {
+			span.SetAttributes(attribute.String("PostSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		span.SetAttributes(attribute.String("PostSystemHandler.Raw", string(body)))
+
+		system, err := ParseSystem(ctx, body)
+		if err != nil {
+			span.SetAttributes(attribute.String("PostSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		system, err = AddSystem(ctx, s.store, system)
+		if err != nil {
+			span.SetAttributes(attribute.String("PostSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(system)
+		if err != nil {
+			span.SetAttributes(attribute.String("PostSystemHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/systems/%s", url.PathEscape(system.Name)))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("PostSystemHandler.Flag", systemsEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) PutSystemHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "PutSystemHandler")
+	defer span.End()
+
+	if systemsEnabled := s.flags.GetBoolFlag(ctx, "manage-systems", s.flags.GetUser(ctx, r)); systemsEnabled {
+		span.SetAttributes(attribute.Bool("PutSystemHandler.Flag", systemsEnabled))
+
+		vars := mux.Vars(r)
+		systemName := vars["name"]
+
+		span.SetAttributes(attribute.String("PutSystemHandler.SystemName", systemName))
+
+		body, err := s.readBody(r)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		span.SetAttributes(attribute.String("PutSystemHandler.Raw", string(body)))
+
+		system, err := ParseSystem(ctx, body)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		system, err = ReplaceSystem(ctx, s.store, systemName, system)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		json, err := json.Marshal(system)
+		if err != nil {
+			span.SetAttributes(attribute.String("PutSystemHandler.Error", err.Error()))
+			httpStatusError(ctx, w, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, string(json))
+
+	} else {
+		span.SetAttributes(attribute.Bool("PutSystemHandler.Flag", systemsEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
+		return
+	}
+}
+
+func (s *SpellService) DeleteSystemHandler(w http.ResponseWriter, r *http.Request) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(r.Context(), "DeleteSystemHandler")
+	defer span.End()
+
+	if systemsEnabled := s.flags.GetBoolFlag(ctx, "manage-systems", s.flags.GetUser(ctx, r)); systemsEnabled {
+		span.SetAttributes(attribute.Bool("DeleteSystemHandler.Flag", systemsEnabled))
+
+		vars := mux.Vars(r)
+		systemName := vars["name"]
+
+		span.SetAttributes(attribute.String("DeleteSystemHandler.SystemName", systemName))
+
+		err := DeleteSystem(ctx, s.store, systemName)
+		if err != nil {
+			span.SetAttributes(attribute.String("DeleteSystemHandler.Error", err.Error()))
+			httpError(ctx, w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	} else {
+		span.SetAttributes(attribute.Bool("DeleteSystemHandler.Flag", systemsEnabled))
+		httpStatusError(ctx, w, http.StatusForbidden)
 		return
 	}
 }
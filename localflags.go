@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/launchdarkly/go-sdk-common.v2/lduser"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	flagEnvPrefix   = "SPELLAPI_FLAG_"
+	flagUserEnvSep  = "__"
+	flagsFileEnvVar = "SPELLAPI_FLAGS_FILE"
+)
+
+// LocalFlags serves feature flags from a JSON/YAML file and environment
+// variables for when LaunchDarkly isn't configured. Environment variables
+// take precedence over the file, including its per-user overrides, and
+// unknown flags are off.
+type LocalFlags struct {
+	Flags map[string]interface{}            `json:"flags" yaml:"flags"`
+	Users map[string]map[string]interface{} `json:"users" yaml:"users"`
+
+	envFlags map[string]interface{}
+	envUsers map[string]map[string]interface{}
+}
+
+// NewLocalFlags loads flags from the file named by SPELLAPI_FLAGS_FILE, if
+// set, and the SPELLAPI_FLAG_* environment variables
+func NewLocalFlags() (*LocalFlags, error) {
+	lf := &LocalFlags{
+		envFlags: map[string]interface{}{},
+		envUsers: map[string]map[string]interface{}{},
+	}
+
+	if path := os.Getenv(flagsFileEnvVar); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read flags file: %v", err)
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, lf)
+		default:
+			err = json.Unmarshal(data, lf)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse flags file %s: %v", path, err)
+		}
+	}
+
+	if lf.Flags == nil {
+		lf.Flags = map[string]interface{}{}
+	}
+	if lf.Users == nil {
+		lf.Users = map[string]map[string]interface{}{}
+	}
+
+	// SPELLAPI_FLAG_DELETE_SPELL=true sets delete-spell for everyone and
+	// SPELLAPI_FLAG_DELETE_SPELL__<userid>=true sets it for a single user
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, flagEnvPrefix) {
+			continue
+		}
+
+		kv := strings.SplitN(strings.TrimPrefix(env, flagEnvPrefix), "=", 2)
+		name, value := kv[0], kv[1]
+
+		user := ""
+		if i := strings.Index(name, flagUserEnvSep); i >= 0 {
+			name, user = name[:i], name[i+len(flagUserEnvSep):]
+		}
+		flag := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+
+		if user == "" {
+			lf.envFlags[flag] = value
+		} else {
+			if lf.envUsers[user] == nil {
+				lf.envUsers[user] = map[string]interface{}{}
+			}
+			lf.envUsers[user][flag] = value
+		}
+	}
+
+	return lf, nil
+}
+
+func (lf *LocalFlags) GetUser(ctx context.Context, r *http.Request) lduser.User {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "LocalFlags.GetUser")
+	defer span.End()
+
+	user := lduser.NewUser(r.Header.Get("X-SPELLAPI-USERID"))
+
+	span.SetAttributes(attribute.Stringer("LocalFlags.GetUser.User", user))
+
+	return user
+}
+
+func (lf *LocalFlags) GetBoolFlag(ctx context.Context, flag string, user lduser.User) bool {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "LocalFlags.GetBoolFlag")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("LocalFlags.GetBoolFlag.Flag", flag))
+	span.SetAttributes(attribute.Stringer("LocalFlags.GetBoolFlag.User", user))
+
+	res := false
+	switch v := lf.lookup(flag, user).(type) {
+	case bool:
+		res = v
+	case string:
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			span.SetAttributes(attribute.String("LocalFlags.GetBoolFlag.Error", err.Error()))
+		}
+		res = parsed
+	}
+	span.SetAttributes(attribute.Bool("LocalFlags.GetBoolFlag.State", res))
+
+	return res
+}
+
+func (lf *LocalFlags) GetIntFlag(ctx context.Context, flag string, user lduser.User) int {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "LocalFlags.GetIntFlag")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("LocalFlags.GetIntFlag.Flag", flag))
+	span.SetAttributes(attribute.Stringer("LocalFlags.GetIntFlag.User", user))
+
+	res := 0
+	switch v := lf.lookup(flag, user).(type) {
+	case int:
+		res = v
+	case float64:
+		res = int(v)
+	case string:
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			span.SetAttributes(attribute.String("LocalFlags.GetIntFlag.Error", err.Error()))
+		}
+		res = parsed
+	}
+	span.SetAttributes(attribute.Int("LocalFlags.GetIntFlag.State", res))
+
+	return res
+}
+
+// lookup returns the value of a flag for a user. The environment variables
+// are checked before the file, and in each a user's override comes before the
+// value for everyone.
+func (lf *LocalFlags) lookup(flag string, user lduser.User) interface{} {
+	layers := []struct {
+		flags map[string]interface{}
+		users map[string]map[string]interface{}
+	}{
+		{lf.envFlags, lf.envUsers},
+		{lf.Flags, lf.Users},
+	}
+
+	for _, layer := range layers {
+		if v, ok := layer.users[user.GetKey()][flag]; ok {
+			return v
+		}
+		if v, ok := layer.flags[flag]; ok {
+			return v
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"gopkg.in/launchdarkly/go-sdk-common.v2/lduser"
+)
+
+var flagsYaml = []byte(`flags:
+  delete-spell: true
+  get-spell-metadata: false
+  bulk-size: 10
+users:
+  alice:
+    delete-spell: false
+    get-spell-metadata: false
+    bulk-size: 5
+`)
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	os.Setenv(key, value)
+	t.Cleanup(func() { os.Unsetenv(key) })
+}
+
+func TestLocalFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.yaml")
+	if err := ioutil.WriteFile(path, flagsYaml, 0600); err != nil {
+		t.Fatalf("WriteFile() err = %v; want nil", err)
+	}
+
+	setEnv(t, "SPELLAPI_FLAGS_FILE", path)
+	setEnv(t, "SPELLAPI_FLAG_GET_SPELL_METADATA", "true")
+	setEnv(t, "SPELLAPI_FLAG_MULTIPOST_SPELL__bob", "true")
+	setEnv(t, "SPELLAPI_FLAG_BULK_SIZE", "20")
+
+	flags, err := spellapi.NewLocalFlags()
+	if err != nil {
+		t.Fatalf("NewLocalFlags() err = %v; want nil", err)
+	}
+
+	ctx := context.Background()
+	testCases := []struct {
+		flag string
+		user string
+		want bool
+	}{
+		{"delete-spell", "", true},
+		{"delete-spell", "alice", false},
+		{"get-spell-metadata", "", true},
+		// The environment wins over the file's overrides for a user too
+		{"get-spell-metadata", "alice", true},
+		{"multipost-spell", "", false},
+		{"multipost-spell", "bob", true},
+		{"unknown-flag", "", false},
+	}
+
+	for _, tc := range testCases {
+		got := flags.GetBoolFlag(ctx, tc.flag, lduser.NewUser(tc.user))
+		if got != tc.want {
+			t.Errorf("GetBoolFlag(%s, %q) = %v; want %v", tc.flag, tc.user, got, tc.want)
+		}
+	}
+
+	for _, user := range []string{"", "alice"} {
+		if got := flags.GetIntFlag(ctx, "bulk-size", lduser.NewUser(user)); got != 20 {
+			t.Errorf("GetIntFlag(bulk-size, %q) = %v; want 20 from the environment", user, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -26,12 +27,50 @@ var (
 
 func main() {
 
+	reportDuplicates := flag.Bool("report-duplicates", false, "list spells that share a name or alias in a system, then exit")
+	migrateNames := flag.Bool("migrate-names", false, "move spells stored with lower case names over to display names and update old lookup keys and search text, then exit")
+	flag.Parse()
+
 	ctx, tp := initHoneycomb()
 	// Handle this error in a sensible manner where possible
 	defer func() { _ = tp.Shutdown(ctx) }()
 
-	dbUrl = os.Getenv("COSMOSDB_URI")
-	db, err := db.ConnectDb(dbUrl)
+	if *reportDuplicates {
+		if err := reportDuplicateSpells(ctx, os.Getenv("COSMOSDB_URI")); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *migrateNames {
+		if err := migrateNamesInDb(ctx, os.Getenv("COSMOSDB_URI")); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var store Store
+	if storeType := os.Getenv("SPELLAPI_STORE"); storeType == "memory" {
+		log.Println("using in-memory spell store, spells will not be persisted")
+		store = db.NewMemoryDB()
+	} else {
+		dbUrl = os.Getenv("COSMOSDB_URI")
+		mongoDb, err := db.ConnectDb(dbUrl)
+		if err != nil {
+			panic(err)
+		}
+		store = mongoDb
+	}
+
+	retention, purgeInterval, err := trashPurgeSettings()
+	if err != nil {
+		panic(err)
+	}
+	if retention > 0 {
+		go runTrashPurge(ctx, store, retention, purgeInterval)
+	}
+
+	maxBodyBytes, err := maxBodySettings()
 	if err != nil {
 		panic(err)
 	}
@@ -43,32 +82,113 @@ func main() {
 			panic(err)
 		}
 
-		spellService = SpellService{
-			store: db,
-			flags: ldclient,
-		}
+		spellService = NewSpellService(store, ldclient, maxBodyBytes)
 	} else {
-		spellService = SpellService{
-			store: db,
+		localFlags, err := NewLocalFlags()
+		if err != nil {
+			panic(err)
 		}
+
+		spellService = NewSpellService(store, localFlags, maxBodyBytes)
+	}
+
+	r := NewRouter(spellService)
+
+	// Bind to a port and pass our router in
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "80"
 	}
+	log.Fatal(http.ListenAndServe(":"+port, r))
+}
 
+// NewRouter routes every endpoint to its handler on spellService. The by-ID
+// routes only match an ObjectID, so they're checked first without hiding the
+// routes for a spell that's called "id".
+func NewRouter(spellService SpellService) *mux.Router {
 	r := mux.NewRouter()
 	r.Use(otelmux.Middleware("SpellApi"))
+	r.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowedHandler)
 	// Routes consist of a path and a handler function.
+	r.HandleFunc("/spells/autocomplete", spellService.AutocompleteHandler).Methods("GET")
+	r.HandleFunc("/spells/id/{id:[0-9a-fA-F]{24}}", spellService.GetSpellByIdHandler).Methods("GET")
+	r.HandleFunc("/spells/id/{id:[0-9a-fA-F]{24}}", spellService.PutSpellHandler).Methods("PUT")
+	r.HandleFunc("/spells/id/{id:[0-9a-fA-F]{24}}", spellService.PatchSpellHandler).Methods("PATCH")
+	r.HandleFunc("/spells/id/{id:[0-9a-fA-F]{24}}", spellService.DeleteSpellHandler).Methods("DELETE")
+	r.HandleFunc("/spells/{name}/suggestions", spellService.GetSpellSuggestionsHandler).Methods("GET")
+	r.HandleFunc("/spells/{name}/revisions", spellService.GetSpellRevisionsHandler).Methods("GET")
+	r.HandleFunc("/spells/{name}/revisions/{n}", spellService.GetSpellRevisionHandler).Methods("GET")
+	r.HandleFunc("/spells/{name}/revisions/{n}/rollback", spellService.RollbackSpellHandler).Methods("POST")
 	r.HandleFunc("/spells/{name}", spellService.GetSpellHandler).Methods("GET")
+	r.HandleFunc("/spells/{name}", spellService.PutSpellHandler).Methods("PUT")
+	r.HandleFunc("/spells/{name}", spellService.PatchSpellHandler).Methods("PATCH")
 	r.HandleFunc("/spells/{name}", spellService.DeleteSpellHandler).Methods("DELETE")
+	r.HandleFunc("/spells:batch", spellService.BatchSpellHandler).Methods("POST")
 	r.HandleFunc("/spells", spellService.PostSpellHandler).Methods("POST")
 	r.HandleFunc("/spells", spellService.GetAllSpellHandler).Methods("GET")
+	r.HandleFunc("/search", spellService.SearchHandler).Methods("GET")
+	r.HandleFunc("/trash", spellService.GetTrashHandler).Methods("GET")
+	r.HandleFunc("/trash/{id}/restore", spellService.RestoreSpellHandler).Methods("POST")
+	r.HandleFunc("/systems/{name}", spellService.GetSystemHandler).Methods("GET")
+	r.HandleFunc("/systems/{name}", spellService.PutSystemHandler).Methods("PUT")
+	r.HandleFunc("/systems/{name}", spellService.DeleteSystemHandler).Methods("DELETE")
+	r.HandleFunc("/systems", spellService.PostSystemHandler).Methods("POST")
+	r.HandleFunc("/systems", spellService.GetSystemsHandler).Methods("GET")
 	r.HandleFunc("/spellmetadata/{name}", spellService.GetSpellMetadataHandler).Methods("GET")
 	r.HandleFunc("/spellmetadata", spellService.GetAllSpellMetadataHandler).Methods("GET")
 
-	// Bind to a port and pass our router in
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "80"
+	return r
+}
+
+// reportDuplicateSpells prints any spells that would stop the unique name or
+// alias indexes from being created so they can be cleaned up by hand
+func reportDuplicateSpells(ctx context.Context, uri string) error {
+	mongoDb, err := db.ConnectDbWithoutIndexes(uri)
+	if err != nil {
+		return err
 	}
-	log.Fatal(http.ListenAndServe(":"+port, r))
+
+	duplicates, err := mongoDb.FindDuplicateSpells(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(duplicates) == 0 {
+		fmt.Println("no duplicate spells found")
+		return nil
+	}
+
+	for _, d := range duplicates {
+		if d.Alias {
+			fmt.Printf("alias %q in system %q is used by %d spells: %v\n", d.Name, d.System, d.Count, d.Ids)
+			continue
+		}
+		fmt.Printf("%q in system %q has %d copies: %v\n", d.Name, d.System, d.Count, d.Ids)
+	}
+
+	return nil
+}
+
+// migrateNamesInDb runs MigrateSpellNames against the database and prints any
+// spells that couldn't be moved over
+func migrateNamesInDb(ctx context.Context, uri string) error {
+	mongoDb, err := db.ConnectDb(uri)
+	if err != nil {
+		return err
+	}
+
+	migration, err := MigrateSpellNames(ctx, mongoDb)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("migrated %d spells, skipped %d\n", migration.Migrated, migration.Skipped)
+	for _, e := range migration.Errors {
+		fmt.Println(e)
+	}
+
+	return nil
 }
 
 func initHoneycomb() (context.Context, *sdktrace.TracerProvider) {
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeName turns a spell name into the key it's looked up by, so that
+// "Melf's Acid Arrow", "melf's acid arrow" and "MELF'S ACID ARROW" are the
+// same spell. Case is folded, accents are removed from Latin letters and runs
+// of spaces are collapsed.
+func normalizeName(name string) string {
+	// Transformers keep state so each call needs its own chain
+	fold := transform.Chain(norm.NFC, cases.Fold())
+
+	key, _, err := transform.String(fold, removeLatinAccents(norm.NFD.String(name)))
+	if err != nil {
+		key = strings.ToLower(name)
+	}
+
+	return strings.Join(strings.Fields(key), " ")
+}
+
+// removeLatinAccents drops the nonspacing marks that follow a Latin letter in
+// decomposed text, so "é" matches "e". Other scripts write vowels and other
+// sounds as marks, and removing them would make different Devanagari or
+// Hebrew names the same, so their marks are kept.
+func removeLatinAccents(decomposed string) string {
+	var b strings.Builder
+	latin := false
+	for _, r := range decomposed {
+		if !unicode.Is(unicode.Mn, r) {
+			latin = unicode.Is(unicode.Latin, r)
+		} else if latin {
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// guessDisplayName capitalizes each word of a name stored before display names
+// were kept. Unlike strings.Title it only looks at spaces, so "melf's acid
+// arrow" becomes "Melf's Acid Arrow" rather than "Melf'S Acid Arrow".
+func guessDisplayName(key string) string {
+	words := strings.Split(key, " ")
+	for i, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		if size > 0 {
+			words[i] = string(unicode.ToTitle(r)) + w[size:]
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// checkNameCharacters makes sure a name only has alphanumeric characters and
+// spaces. Letters and numbers can be from any language, along with the marks
+// some scripts combine with them, and apostrophes are allowed as part of a
+// word so names like "Melf's Acid Arrow" can be used.
+func checkNameCharacters(field string, name string) error {
+	for _, r := range name {
+		if isWordCharacter(r) || unicode.Is(unicode.Zs, r) {
+			continue
+		}
+
+		return newValidationError(field, "%s can't contain %q, only letters, numbers, apostrophes and spaces", field, r)
+	}
+
+	return nil
+}
+
+// checkDescriptionCharacters makes sure a description follows the same rule as
+// names, except that any kind of space or line break is allowed, such as the
+// non-breaking spaces in text pasted from a sourcebook.
+func checkDescriptionCharacters(field string, description string) error {
+	for _, r := range description {
+		if isWordCharacter(r) || unicode.IsSpace(r) {
+			continue
+		}
+
+		return newValidationError(field, "%s can't contain %q, only letters, numbers, apostrophes, spaces and line breaks", field, r)
+	}
+
+	return nil
+}
+
+func isWordCharacter(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '\'' || r == '’'
+}
+
+// checkSpellCharacters applies the character rules to a spell's name, aliases
+// and description. Only the ones that are new compared to existing are
+// checked, so spells saved before the rules were added can still be edited
+// without renaming them. existing is nil for a new spell.
+func checkSpellCharacters(spell Spell, existing *Spell) error {
+	if existing == nil || spell.Name != existing.Name {
+		if err := checkNameCharacters("name", spell.Name); err != nil {
+			return err
+		}
+	}
+
+	known := map[string]bool{}
+	if existing != nil {
+		for _, alias := range existing.Aliases {
+			known[alias] = true
+		}
+	}
+	for _, alias := range spell.Aliases {
+		if known[alias] {
+			continue
+		}
+		if err := checkNameCharacters("aliases", alias); err != nil {
+			return err
+		}
+	}
+
+	if existing == nil || spell.Description != existing.Description {
+		if err := checkDescriptionCharacters("description", spell.Description); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NameMigration counts what MigrateSpellNames did
+type NameMigration struct {
+	Migrated int
+	Skipped  int
+	Errors   []string
+}
+
+// MigrateSpellNames brings the names of spells saved by older versions up to
+// date. Spells stored before display names were kept had their names stored
+// lower case so the display name is a guess from guessDisplayName, and every
+// spell whose name or alias keys were normalized differently, such as before
+// accents were only removed from Latin letters, is keyed again. Spells saved
+// before spelldata was copied into searchText get it filled in. Spells whose
+// new keys collide with another spell are left alone and reported.
+func MigrateSpellNames(ctx context.Context, db Store) (NameMigration, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "MigrateSpellNames")
+	defer span.End()
+
+	results, err := db.GetSpell(ctx, bson.M{})
+	if err != nil {
+		span.SetAttributes(attribute.String("MigrateSpellNames.Error", err.Error()))
+		return NameMigration{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	spells, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("MigrateSpellNames.Error", err.Error()))
+		return NameMigration{}, err
+	}
+
+	migration := NameMigration{}
+	for i, spell := range spells {
+		oldKey := spell.Key
+		oldAliasKeys := spell.AliasKeys
+
+		// Only while it's still the version that was read, so an edit made
+		// through the API since isn't overwritten
+		filter := versionFilter(spell)
+		if _, ok := results[i]["displayName"]; ok {
+			spell.setKeys()
+			searchText, _ := results[i]["searchText"].(string)
+			if spell.Key == oldKey && sameKeys(spell.AliasKeys, oldAliasKeys) && searchText == spellDataText(spell.SpellData) {
+				continue
+			}
+		} else {
+			spell.Key = normalizeName(spell.Name)
+			filter["displayName"] = bson.M{"$exists": false}
+		}
+
+		bsonSpell, err := bson.Marshal(spell)
+		if err != nil {
+			span.SetAttributes(attribute.String("MigrateSpellNames.Error", err.Error()))
+			return migration, fmt.Errorf("failed to marshall data: %w", err)
+		}
+
+		err = db.UpdateSpell(ctx, filter, bsonSpell)
+		if errors.Is(err, ErrConflict) {
+			migration.Skipped++
+			migration.Errors = append(migration.Errors,
+				fmt.Sprintf("%q in system %q: another spell is already called %q or has one of its aliases", oldKey, spell.Metadata.System, spell.Key))
+			continue
+		} else if errors.Is(err, ErrNotFound) {
+			// Changed or deleted since it was read, either way there's
+			// nothing left to migrate
+			migration.Skipped++
+			continue
+		} else if err != nil {
+			span.SetAttributes(attribute.String("MigrateSpellNames.Error", err.Error()))
+			return migration, fmt.Errorf("failed to update spell in DB: %w", err)
+		}
+
+		migration.Migrated++
+	}
+
+	span.SetAttributes(
+		attribute.Int("MigrateSpellNames.Migrated", migration.Migrated),
+		attribute.Int("MigrateSpellNames.Skipped", migration.Skipped),
+	)
+
+	return migration, nil
+}
+
+func sameKeys(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,151 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMigrateSpellNames(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+
+	// Spells as they were stored before display names were kept
+	for _, doc := range []bson.M{
+		{"name": "melf's acid arrow", "description": "Acid", "metadata": bson.M{"system": "test1"}},
+		{"name": "éclair de feu", "description": "Lightning", "metadata": bson.M{"system": "test1"}},
+		{"name": "eclair de feu", "description": "Other lightning", "metadata": bson.M{"system": "test1"}},
+	} {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatalf("Marshal() err = %v; want nil", err)
+		}
+		if err := store.AddSpell(ctx, raw); err != nil {
+			t.Fatalf("AddSpell() err = %v; want nil", err)
+		}
+	}
+
+	got, err := spellapi.FindSpell(ctx, store, "melf's acid arrow", url.Values{})
+	if err != nil || got.Name != "Melf's Acid Arrow" {
+		t.Errorf("FindSpell() before migrating = %q, %v; want Melf's Acid Arrow", got.Name, err)
+	}
+
+	migration, err := spellapi.MigrateSpellNames(ctx, store)
+	if err != nil {
+		t.Fatalf("MigrateSpellNames() err = %v; want nil", err)
+	}
+
+	// The accented name folds to the same key as the other one so it can't
+	// be moved over
+	if migration.Migrated != 2 || migration.Skipped != 1 || len(migration.Errors) != 1 {
+		t.Errorf("MigrateSpellNames() = %+v; want 2 migrated and 1 skipped", migration)
+	}
+
+	got, err = spellapi.FindSpell(ctx, store, "MELF'S ACID ARROW", url.Values{})
+	if err != nil || got.Name != "Melf's Acid Arrow" {
+		t.Errorf("FindSpell() after migrating = %q, %v; want Melf's Acid Arrow", got.Name, err)
+	}
+
+	again, err := spellapi.MigrateSpellNames(ctx, store)
+	if err != nil || again.Migrated != 0 {
+		t.Errorf("MigrateSpellNames() again = %+v, %v; want nothing left but the collision", again, err)
+	}
+
+	// Saved before spelldata was copied into searchText
+	raw, _ := bson.Marshal(bson.M{"name": "shield", "displayName": "Shield", "description": "Blocks", "spelldata": bson.M{"school": "abjuration"}, "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+	if found, err := spellapi.SearchSpells(ctx, store, "abjuration", url.Values{}, 10); err != nil || len(found) != 0 {
+		t.Errorf("SearchSpells(abjuration) before migrating = %d results, %v; want none", len(found), err)
+	}
+
+	migration, err = spellapi.MigrateSpellNames(ctx, store)
+	if err != nil || migration.Migrated != 1 {
+		t.Errorf("MigrateSpellNames() = %+v, %v; want the search text filled in", migration, err)
+	}
+	if found, err := spellapi.SearchSpells(ctx, store, "abjuration", url.Values{}, 10); err != nil || len(found) != 1 {
+		t.Errorf("SearchSpells(abjuration) after migrating = %d results, %v; want shield", len(found), err)
+	}
+
+	// Keyed when every mark was removed, rather than only Latin accents
+	raw, _ = bson.Marshal(bson.M{"name": "कल", "displayName": "कुल", "aliases": bson.A{"Kul"}, "aliasKeys": bson.A{"kul"}, "description": "Clan", "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+
+	migration, err = spellapi.MigrateSpellNames(ctx, store)
+	if err != nil || migration.Migrated != 1 {
+		t.Errorf("MigrateSpellNames() = %+v, %v; want the old key moved over", migration, err)
+	}
+
+	got, err = spellapi.FindSpell(ctx, store, "कुल", url.Values{})
+	if err != nil || got.Description != "Clan" {
+		t.Errorf("FindSpell(कुल) after migrating = %v, %v; want Clan", got, err)
+	}
+	if got, err := spellapi.FindSpell(ctx, store, "कल", url.Values{}); err != nil || got.Name != "" {
+		t.Errorf("FindSpell(कल) after migrating = %v, %v; want no spell", got, err)
+	}
+}
+
+func TestSpellCharacters(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+
+	testCases := []struct {
+		input string
+		field string
+	}{
+		{`{"name":"<script>","description":"example","metadata":{"system":"test"}}`, "name"},
+		{`{"name":"Tasha's Hideous Laughter!","description":"example","metadata":{"system":"test"}}`, "name"},
+		{`{"name":"test1","aliases":["test_2"],"description":"example","metadata":{"system":"test"}}`, "aliases"},
+		{`{"name":"test1","description":"exa\u202emple","metadata":{"system":"test"}}`, "description"},
+		{`{"name":"test2","description":"A sword. It hits for 3d10","metadata":{"system":"test"}}`, "description"},
+		{`{"name":"Mordenkainen's Sword","description":"A sword\nIt hits for\u00a03d10","metadata":{"system":"test"}}`, ""},
+		{`{"name":"Tenser’s Floating Disk","description":"Tenser’s disk","metadata":{"system":"test"}}`, ""},
+		{`{"name":"Éclair de Feu","description":"Lightning","metadata":{"system":"test"}}`, ""},
+		{`{"name":"अग्नि बाण","description":"Fire arrow","metadata":{"system":"test"}}`, ""},
+	}
+
+	for _, v := range testCases {
+		spell, err := spellapi.ParseSpell(ctx, store, []byte(v.input))
+		if err != nil {
+			t.Fatalf("ParseSpell(%s) err = %v; want nil", v.input, err)
+		}
+
+		_, err = spellapi.AddSpell(ctx, store, spell, "tester")
+
+		var validationErr *spellapi.ValidationError
+		if v.field == "" && err != nil {
+			t.Errorf("AddSpell(%s) err = %v; want nil", v.input, err)
+		} else if v.field != "" && (!errors.As(err, &validationErr) || validationErr.Field != v.field) {
+			t.Errorf("AddSpell(%s) err = %v; want a validation error on %s", v.input, err, v.field)
+		}
+	}
+
+	// Saved before the rules were added, it can be changed as long as the
+	// name and description are left alone
+	raw, _ := bson.Marshal(bson.M{"name": "melf's acid arrow (2e)", "displayName": "Melf's Acid Arrow (2e)", "description": "Acid, lots of it.", "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+
+	if _, err := spellapi.PatchSpell(ctx, store, "melf's acid arrow (2e)", url.Values{}, []byte(`{"spelldata":{"level":2}}`), spellapi.MergePatchContentType, "", "tester"); err != nil {
+		t.Errorf("PatchSpell(spelldata) err = %v; want nil", err)
+	}
+
+	_, err := spellapi.PatchSpell(ctx, store, "melf's acid arrow (2e)", url.Values{}, []byte(`{"description":"More acid."}`), spellapi.MergePatchContentType, "", "tester")
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("PatchSpell(description) err = %v; want %v", err, spellapi.ErrValidation)
+	}
+
+	_, err = spellapi.PatchSpell(ctx, store, "melf's acid arrow (2e)", url.Values{}, []byte(`{"name":"Melf's Arrow (2e)"}`), spellapi.MergePatchContentType, "", "tester")
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("PatchSpell(name) err = %v; want %v", err, spellapi.ErrValidation)
+	}
+}
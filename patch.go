@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+	InvalidPatch          = "invalid patch"
+)
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to a decoded JSON
+// document.
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = applyMergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to a decoded JSON document.
+// Operations are applied in order and the first failure aborts the patch.
+func applyJSONPatch(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		doc, err = applyPatchOperation(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func applyPatchOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err = pointerRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			} else if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("cannot move a value into one of its children")
+			}
+
+			doc, err = pointerRemove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value, err = copyJSONValue(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return pointerAdd(doc, path, value)
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	} else if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("path %q not found", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerSet overwrites the existing value at path, used to store arrays
+// that have changed length back into their parent.
+func pointerSet(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[len(path)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		c[key] = value
+	case []interface{}:
+		i, err := arrayIndex(key, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = value
+	default:
+		return nil, fmt.Errorf("path %q not found", key)
+	}
+
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parentPath := path[:len(path)-1]
+	parent, err := pointerGet(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[len(path)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		c[key] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(c), true)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, nil)
+		copy(c[i+1:], c[i:])
+		c[i] = value
+		return pointerSet(doc, parentPath, c)
+	}
+
+	return nil, fmt.Errorf("path %q not found", key)
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	parentPath := path[:len(path)-1]
+	parent, err := pointerGet(doc, parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key := path[len(path)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := c[key]; !ok {
+			return nil, fmt.Errorf("path %q not found", key)
+		}
+		delete(c, key)
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		return pointerSet(doc, parentPath, append(c[:i:i], c[i+1:]...))
+	}
+
+	return nil, fmt.Errorf("path %q not found", key)
+}
+
+// arrayIndex parses an array index token, allowing "-" or the array length
+// to refer to the end of the array when adding.
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return length, nil
+	}
+
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	if i > length || (i == length && !adding) {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+func copyJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var c interface{}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
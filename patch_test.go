@@ -0,0 +1,85 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+)
+
+func TestPatchSpell(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		patch       string
+		result      error
+		want        func(spellapi.Spell) bool
+	}{
+		{
+			spellapi.MergePatchContentType,
+			`{"spelldata":{"level":4,"school":null}}`,
+			nil,
+			func(s spellapi.Spell) bool {
+				_, hasSchool := s.SpellData["school"]
+				return s.SpellData["level"] == float64(4) && !hasSchool && s.Description == "Big boom"
+			},
+		},
+		{
+			spellapi.JSONPatchContentType,
+			`[{"op":"test","path":"/spelldata/level","value":3},{"op":"replace","path":"/spelldata/level","value":5},{"op":"add","path":"/spelldata/tags/-","value":"aoe"}]`,
+			nil,
+			func(s spellapi.Spell) bool {
+				tags, _ := s.SpellData["tags"].([]interface{})
+				return s.SpellData["level"] == float64(5) && len(tags) == 2 && tags[1] == "aoe"
+			},
+		},
+		{
+			spellapi.JSONPatchContentType,
+			`[{"op":"move","from":"/spelldata/school","path":"/spelldata/type"}]`,
+			nil,
+			func(s spellapi.Spell) bool {
+				_, hasSchool := s.SpellData["school"]
+				return s.SpellData["type"] == "evocation" && !hasSchool
+			},
+		},
+		{spellapi.JSONPatchContentType, `[{"op":"test","path":"/spelldata/level","value":9}]`, spellapi.ErrValidation, nil},
+		{spellapi.JSONPatchContentType, `[{"op":"remove","path":"/spelldata/range"}]`, spellapi.ErrValidation, nil},
+		{spellapi.JSONPatchContentType, `[{"op":"remove","path":"/description"}]`, spellapi.ErrValidation, nil},
+		{spellapi.MergePatchContentType, `{"name":"cure wounds"}`, spellapi.ErrConflict, nil},
+	}
+
+	for i, v := range testCases {
+		ctx := context.Background()
+		store := db.NewMemoryDB()
+		addTestSpells(t, store,
+			`{"name":"fireball","description":"Big boom","spelldata":{"level":3,"school":"evocation","tags":["fire"]},"metadata":{"system":"test1"}}`,
+			`{"name":"cure wounds","description":"Heals","metadata":{"system":"test1"}}`,
+		)
+
+		query := url.Values{"system": []string{"test1"}}
+		got, err := spellapi.PatchSpell(ctx, store, "fireball", query, []byte(v.patch), v.contentType, "", "tester")
+		if v.result != nil {
+			if !errors.Is(err, v.result) {
+				t.Errorf("case %d: PatchSpell() err = %v; want %v", i, err, v.result)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("case %d: PatchSpell() err = %v; want nil", i, err)
+			continue
+		}
+
+		if !v.want(got) {
+			t.Errorf("case %d: PatchSpell() returned %v", i, got)
+		}
+
+		stored, err := spellapi.FindSpell(ctx, store, "fireball", query)
+		if err != nil {
+			t.Fatalf("case %d: FindSpell() err = %v; want nil", i, err)
+		}
+		if stored.String() != got.String() {
+			t.Errorf("case %d: stored spell %v; want %v", i, stored, got)
+		}
+	}
+}
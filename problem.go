@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem types for errors the API knows about, anything else uses
+// about:blank and the status text as the title
+const (
+	ValidationProblem   = "urn:spellapi:problem:validation-error"
+	NotFoundProblem     = "urn:spellapi:problem:not-found"
+	ConflictProblem     = "urn:spellapi:problem:conflict"
+	AmbiguousProblem    = "urn:spellapi:problem:ambiguous-match"
+	UnavailableProblem  = "urn:spellapi:problem:store-unavailable"
+	PreconditionProblem = "urn:spellapi:problem:precondition-failed"
+	TooLargeProblem     = "urn:spellapi:problem:request-too-large"
+	BatchAbortedProblem = "urn:spellapi:problem:batch-aborted"
+	AtomicProblem       = "urn:spellapi:problem:atomic-unsupported"
+	defaultProblemType  = "about:blank"
+)
+
+// Problem is an RFC 7807 problem details body, returned for every error.
+// Field is the part of the request at fault, Errors lists each field when
+// there's more than one, and TraceId ties the response to its trace.
+type Problem struct {
+	Type    string         `json:"type"`
+	Title   string         `json:"title"`
+	Status  int            `json:"status"`
+	Detail  string         `json:"detail,omitempty"`
+	Field   string         `json:"field,omitempty"`
+	Errors  []FieldProblem `json:"errors,omitempty"`
+	TraceId string         `json:"traceId,omitempty"`
+}
+
+// FieldProblem is one of the fields at fault in a validation problem
+type FieldProblem struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// newProblem starts a problem for a status with no more specific type
+func newProblem(ctx context.Context, status int, detail string) Problem {
+	p := Problem{
+		Type:   defaultProblemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		p.TraceId = sc.TraceID().String()
+	}
+
+	return p
+}
+
+// problemFromError describes an error from the spell functions, server errors
+// don't include the detail so nothing internal leaks out
+func problemFromError(ctx context.Context, err error) Problem {
+	status := statusFromError(err)
+	p := newProblem(ctx, status, "")
+	if status < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+
+	var validationErr *ValidationError
+	var validationErrs ValidationErrors
+	switch {
+	case errors.As(err, &validationErrs):
+		p.Type = ValidationProblem
+		for _, v := range validationErrs {
+			p.Errors = append(p.Errors, FieldProblem{Field: v.Field, Detail: v.Message})
+		}
+	case errors.As(err, &validationErr):
+		p.Type = ValidationProblem
+		p.Field = validationErr.Field
+	case errors.Is(err, ErrAmbiguousMatch):
+		p.Type = AmbiguousProblem
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrSystemNotFound):
+		p.Type = NotFoundProblem
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrSystemConflict):
+		p.Type = ConflictProblem
+	case errors.Is(err, ErrPreconditionFailed), errors.Is(err, ErrPreconditionRequired):
+		p.Type = PreconditionProblem
+	case errors.Is(err, ErrRequestTooLarge):
+		p.Type = TooLargeProblem
+	case errors.Is(err, ErrBatchAborted):
+		p.Type = BatchAbortedProblem
+	case errors.Is(err, ErrTransactionsUnsupported):
+		p.Type = AtomicProblem
+		p.Detail = "atomic batches need MongoDB to run as a replica set or sharded cluster"
+	case errors.Is(err, ErrStoreUnavailable):
+		p.Type = UnavailableProblem
+	}
+
+	return p
+}
+
+// writeProblem writes any problem body, including ones with extension members
+func writeProblem(w http.ResponseWriter, status int, problem interface{}) {
+	body, err := json.Marshal(problem)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// httpError responds with the problem for an error from the spell functions
+func httpError(ctx context.Context, w http.ResponseWriter, err error) {
+	p := problemFromError(ctx, err)
+	writeProblem(w, p.Status, p)
+}
+
+// httpStatusError responds with a problem that only has a status
+func httpStatusError(ctx context.Context, w http.ResponseWriter, status int) {
+	writeProblem(w, status, newProblem(ctx, status, ""))
+}
+
+// NotFoundHandler and MethodNotAllowedHandler replace the router's plain text
+// responses for unknown routes
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	httpStatusError(r.Context(), w, http.StatusNotFound)
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	httpStatusError(r.Context(), w, http.StatusMethodNotAllowed)
+}
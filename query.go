@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const InvalidQuery = "invalid query"
+
+// Operators that can be added to a query parameter, as in level[gte]=3
+var queryOperators = map[string]string{
+	"eq":     "$in",
+	"ne":     "$nin",
+	"gt":     "$gt",
+	"gte":    "$gte",
+	"lt":     "$lt",
+	"lte":    "$lte",
+	"prefix": "$regex",
+	"exists": "$exists",
+}
+
+// A query parameter split into its field and operator
+type queryParam struct {
+	field  string
+	op     string
+	values []string
+}
+
+// addQueryFilters adds the filters for any URL query parameters to a spell
+// query. system and creator match the spell's metadata, name matches the
+// spell's name, updatedSince matches spells changed at or after a time and
+// anything else matches a key in spelldata. Parameters can have an operator
+// such as level[gte]=3, without one they match any of the given values.
+// Values for spelldata are converted to the types that key holds, see
+// spellDataKinds.
+func addQueryFilters(ctx context.Context, db Store, bsonQuery bson.M, query url.Values) error {
+	params := []queryParam{}
+	for k, v := range query {
+		if k == "updatedSince" {
+			since, err := parseQueryTime(k, v)
+			if err != nil {
+				return err
+			}
+			bsonQuery["metadata.updatedAt"] = bson.M{"$gte": since}
+			continue
+		}
+
+		field, op, err := parseQueryKey(k)
+		if err != nil {
+			return err
+		}
+
+		params = append(params, queryParam{field, op, v})
+	}
+
+	// The system filter goes first so the spelldata types can be looked up
+	// for the spells being searched
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].field == "system" && params[j].field != "system"
+	})
+
+	for _, p := range params {
+		field, op, v := p.field, p.op, p.values
+
+		path := fmt.Sprintf("spelldata.%s", field)
+		switch field {
+		case "system":
+			path = "metadata.system"
+		case "creator":
+			path = "metadata.creator"
+		case "name":
+			path = "name"
+		}
+
+		var kinds map[string]bool
+		if path == fmt.Sprintf("spelldata.%s", field) && op != "prefix" && op != "exists" {
+			var err error
+			kinds, err = spellDataKinds(ctx, db, field, bsonQuery, query)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Systems are matched by any of their registered names
+		if field == "system" && (op == "eq" || op == "ne") {
+			spellings := []string{}
+			for _, name := range v {
+				names, err := systemSpellings(ctx, db, name)
+				if err != nil {
+					return err
+				}
+				spellings = append(spellings, names...)
+			}
+			v = spellings
+		}
+
+		mongoOp := queryOperators[op]
+		value, err := queryOperand(field, op, v, kinds)
+		if err != nil {
+			return err
+		}
+
+		filter, ok := bsonQuery[path].(bson.M)
+		if !ok {
+			filter = bson.M{}
+			bsonQuery[path] = filter
+		}
+
+		if _, exists := filter[mongoOp]; exists {
+			return newValidationError(field, "%s: %s has conflicting filters", InvalidQuery, field)
+		}
+		filter[mongoOp] = value
+
+		if op == "prefix" && field != "name" {
+			filter["$options"] = "i"
+		}
+	}
+
+	return nil
+}
+
+// spellDataKinds works out which JSON types ("string", "number" and
+// "boolean") a spelldata key holds, so query values can be converted to
+// match. When the query is for one registered system whose schema describes
+// the key the schema is used, otherwise the types of the values already
+// saved in the systems being searched. A key nothing has been saved under
+// has no kinds.
+func spellDataKinds(ctx context.Context, db Store, field string, bsonQuery bson.M, query url.Values) (map[string]bool, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "SpellDataKinds")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("SpellDataKinds.Field", field))
+
+	kinds := map[string]bool{}
+
+	if systems := query["system"]; len(systems) == 1 {
+		system, err := FindSystem(ctx, db, systems[0])
+		if err != nil && !errors.Is(err, ErrSystemNotFound) {
+			span.SetAttributes(attribute.String("SpellDataKinds.Error", err.Error()))
+			return nil, err
+		}
+
+		if err == nil && len(system.Schema) > 0 {
+			schema, err := parseSchema("schema", system.Schema)
+			if err == nil {
+				addSchemaKinds(kinds, schemaProperty(schema, field))
+			}
+		}
+
+		if len(kinds) > 0 {
+			span.SetAttributes(attribute.String("SpellDataKinds.Source", "schema"))
+			return kinds, nil
+		}
+	}
+
+	search := bson.M{}
+	if system, ok := bsonQuery["metadata.system"]; ok {
+		search["metadata.system"] = system
+	}
+
+	types, err := db.GetValueTypes(ctx, fmt.Sprintf("spelldata.%s", field), search)
+	if err != nil {
+		span.SetAttributes(attribute.String("SpellDataKinds.Error", err.Error()))
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	for _, t := range types {
+		switch t {
+		case "string":
+			kinds["string"] = true
+		case "double", "int", "long", "decimal":
+			kinds["number"] = true
+		case "bool":
+			kinds["boolean"] = true
+		}
+	}
+
+	span.SetAttributes(attribute.String("SpellDataKinds.Source", "stored"))
+
+	return kinds, nil
+}
+
+// schemaProperty returns the schema for one key of an object schema, or nil
+func schemaProperty(schema interface{}, field string) interface{} {
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	properties, ok := object["properties"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return properties[field]
+}
+
+// addSchemaKinds adds the kinds of value a schema allows, from its type or
+// the values in its enum or const. Arrays add the kinds of their items, as a
+// query matches against each element.
+func addSchemaKinds(kinds map[string]bool, schema interface{}) {
+	object, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if types, ok := schemaTypeList(object["type"]); ok {
+		for _, t := range types {
+			switch t {
+			case "string":
+				kinds["string"] = true
+			case "number", "integer":
+				kinds["number"] = true
+			case "boolean":
+				kinds["boolean"] = true
+			case "array":
+				addSchemaKinds(kinds, object["items"])
+			}
+		}
+		return
+	}
+
+	values, _ := object["enum"].([]interface{})
+	if c, ok := object["const"]; ok {
+		values = append(values, c)
+	}
+	for _, v := range values {
+		switch v.(type) {
+		case string:
+			kinds["string"] = true
+		case float64:
+			kinds["number"] = true
+		case bool:
+			kinds["boolean"] = true
+		}
+	}
+}
+
+// parseQueryKey splits a query parameter such as level[gte] into the field
+// and operator.
+func parseQueryKey(key string) (string, string, error) {
+	open := strings.Index(key, "[")
+	if open < 0 {
+		if strings.Contains(key, "]") || key == "" {
+			return "", "", newValidationError(key, "%s: malformed parameter %q", InvalidQuery, key)
+		}
+		return key, "eq", nil
+	}
+
+	if open == 0 || !strings.HasSuffix(key, "]") || strings.Count(key, "[") != 1 || strings.Count(key, "]") != 1 {
+		return "", "", newValidationError(key, "%s: malformed parameter %q", InvalidQuery, key)
+	}
+
+	field, op := key[:open], key[open+1:len(key)-1]
+	if _, ok := queryOperators[op]; !ok {
+		return "", "", newValidationError(field, "%s: unknown operator %q for %s", InvalidQuery, op, field)
+	}
+
+	return field, op, nil
+}
+
+// queryOperand converts the values for a parameter into the operand for its
+// operator. kinds are the types a spelldata key holds, from spellDataKinds.
+func queryOperand(field string, op string, values []string, kinds map[string]bool) (interface{}, error) {
+	if op != "eq" && op != "ne" && len(values) != 1 {
+		return nil, newValidationError(field, "%s: %s[%s] takes a single value", InvalidQuery, field, op)
+	}
+
+	switch op {
+	case "eq", "ne":
+		if field == "name" {
+			return normalizeNames(values), nil
+		} else if field == "system" || field == "creator" {
+			return values, nil
+		}
+		return coerceQueryValues(field, values, kinds)
+	case "prefix":
+		if values[0] == "" {
+			return nil, newValidationError(field, "%s: %s[prefix] needs a value", InvalidQuery, field)
+		}
+		prefix := values[0]
+		if field == "name" {
+			prefix = normalizeName(prefix)
+		}
+		return "^" + regexp.QuoteMeta(prefix), nil
+	case "exists":
+		exists, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, newValidationError(field, "%s: %s[exists] must be true or false", InvalidQuery, field)
+		}
+		return exists, nil
+	}
+
+	if field == "name" {
+		return normalizeName(values[0]), nil
+	} else if field == "system" || field == "creator" {
+		return values[0], nil
+	}
+
+	// Mongo only compares values of the same type, so a key that holds
+	// numbers is compared as a number. Without any saved values numbers
+	// are still compared as numbers when they can be.
+	n, isNumber := parseNumber(values[0])
+	switch {
+	case isNumber && (kinds["number"] || len(kinds) == 0):
+		return n, nil
+	case kinds["string"] || len(kinds) == 0:
+		return values[0], nil
+	}
+
+	return nil, newValidationError(field, "%s: %s[%s] must be %s", InvalidQuery, field, op, describeKinds(kinds))
+}
+
+// coerceQueryValues converts URL query values into the types a spelldata key
+// holds, so ?level=2 finds "level": 2 when levels are numbers, and "level":
+// "2" when they're strings. A value that can't be any of them is a
+// validation error rather than a filter that can never match.
+func coerceQueryValues(field string, values []string, kinds map[string]bool) ([]interface{}, error) {
+	// Nothing's been saved under the key so nothing can match
+	if len(kinds) == 0 {
+		coerced := make([]interface{}, len(values))
+		for i, v := range values {
+			coerced[i] = v
+		}
+		return coerced, nil
+	}
+
+	coerced := []interface{}{}
+	for _, v := range values {
+		matched := false
+		if kinds["string"] {
+			coerced = append(coerced, v)
+			matched = true
+		}
+
+		if n, ok := parseNumber(v); ok && kinds["number"] {
+			coerced = append(coerced, n)
+			matched = true
+		}
+
+		if kinds["boolean"] {
+			switch strings.ToLower(v) {
+			case "true":
+				coerced = append(coerced, true)
+				matched = true
+			case "false":
+				coerced = append(coerced, false)
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, newValidationError(field, "%s: %s must be %s, not %q", InvalidQuery, field, describeKinds(kinds), v)
+		}
+	}
+
+	return coerced, nil
+}
+
+// describeKinds lists kinds for an error message, such as "a number or
+// a boolean"
+func describeKinds(kinds map[string]bool) string {
+	names := []string{}
+	for _, k := range []string{"string", "number", "boolean"} {
+		if kinds[k] {
+			names = append(names, "a "+k)
+		}
+	}
+
+	return strings.Join(names, " or ")
+}
+
+// parseQueryTime reads a single RFC 3339 time, or a date on its own for the
+// start of that day in UTC
+func parseQueryTime(field string, values []string) (time.Time, error) {
+	if len(values) != 1 {
+		return time.Time{}, newValidationError(field, "%s: %s takes a single value", InvalidQuery, field)
+	}
+
+	if t, err := time.Parse(time.RFC3339, values[0]); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", values[0]); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, newValidationError(field, "%s: %s must be a date or an RFC 3339 time", InvalidQuery, field)
+}
+
+func parseNumber(v string) (float64, bool) {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, false
+	}
+	return n, true
+}
+
+func normalizeNames(values []string) []string {
+	normalized := make([]string, len(values))
+	for i, v := range values {
+		normalized[i] = normalizeName(v)
+	}
+	return normalized
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest request body accepted when SPELLAPI_MAX_BODY_BYTES isn't set
+const defaultMaxBodyBytes = 1 << 20
+
+// Fields a spell can have in a request body, checked in strict mode. A nil
+// value means anything is allowed inside that field, spelldata is checked by
+// the system's schema instead. id and matchedAlias are ignored but allowed so
+// a spell that's been read can be sent straight back.
+var knownSpellFields = map[string]interface{}{
+	"id":           nil,
+	"name":         nil,
+	"aliases":      nil,
+	"matchedAlias": nil,
+	"description":  nil,
+	"spelldata":    nil,
+	"metadata": map[string]interface{}{
+		"system":    nil,
+		"creator":   nil,
+		"createdAt": nil,
+		"updatedAt": nil,
+	},
+}
+
+// maxBodySettings reads the largest request body that's accepted
+func maxBodySettings() (int64, error) {
+	if v := os.Getenv("SPELLAPI_MAX_BODY_BYTES"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("invalid SPELLAPI_MAX_BODY_BYTES %q", v)
+		}
+		return parsed, nil
+	}
+
+	return defaultMaxBodyBytes, nil
+}
+
+// readBody reads a request body, failing with ErrRequestTooLarge rather than
+// reading any more than the configured limit
+func (s *SpellService) readBody(r *http.Request) ([]byte, error) {
+	limit := s.maxBodyBytes
+	if limit <= 0 {
+		limit = defaultMaxBodyBytes
+	}
+
+	if r.ContentLength > limit {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrRequestTooLarge, limit)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, newValidationError("", "failed to read request body: %v", err)
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrRequestTooLarge, limit)
+	}
+
+	return body, nil
+}
+
+// strictHandling reports whether the client asked for strict validation with
+// a Prefer: handling=strict header (RFC 7240), and if so says it was applied
+func strictHandling(w http.ResponseWriter, r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name := strings.TrimSpace(strings.SplitN(preference, ";", 2)[0])
+			if strings.EqualFold(strings.ReplaceAll(name, " ", ""), "handling=strict") {
+				w.Header().Set("Preference-Applied", "handling=strict")
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkSpellFields rejects any fields in a spell that aren't part of the
+// Spell type, such as a misspelt "spellData", which would otherwise be
+// silently dropped. Every unknown field is listed with its path.
+func checkSpellFields(in []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(in, &doc); err != nil {
+		return newValidationError("", "invalid spell: %v", err)
+	}
+
+	errs := unknownFields(doc, knownSpellFields, "")
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+
+	return nil
+}
+
+// checkPatchFields is checkSpellFields for a patch, checking the fields a
+// merge patch sets or the paths a JSON patch changes
+func checkPatchFields(patch []byte, contentType string) error {
+	if contentType != JSONPatchContentType {
+		return checkSpellFields(patch)
+	}
+
+	var ops []struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		From  *string     `json:"from"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		// Left for the patch to report
+		return nil
+	}
+
+	errs := []*ValidationError{}
+	for _, op := range ops {
+		paths := []string{op.Path}
+		if op.From != nil {
+			paths = append(paths, *op.From)
+		}
+
+		for _, path := range paths {
+			if path == "" {
+				// Replacing the whole spell
+				errs = append(errs, unknownFields(op.Value, knownSpellFields, "")...)
+				continue
+			}
+
+			if field, ok := unknownPointer(path); !ok {
+				errs = append(errs, &ValidationError{Field: field, Message: "unknown field"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return ValidationErrors(errs)
+	}
+
+	return nil
+}
+
+// unknownFields lists every key in doc that isn't in known, with its path
+// from prefix
+func unknownFields(doc interface{}, known map[string]interface{}, prefix string) []*ValidationError {
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	// Sorted so the errors come back in the same order every time
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := []*ValidationError{}
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = fmt.Sprintf("%s.%s", prefix, k)
+		}
+
+		child, ok := known[k]
+		if !ok {
+			errs = append(errs, &ValidationError{Field: path, Message: "unknown field"})
+			continue
+		}
+
+		if fields, ok := child.(map[string]interface{}); ok {
+			errs = append(errs, unknownFields(object[k], fields, path)...)
+		}
+	}
+
+	return errs
+}
+
+// unknownPointer checks a JSON pointer into a spell only goes through known
+// fields, returning the path as a field name
+func unknownPointer(pointer string) (string, bool) {
+	known := knownSpellFields
+	path := []string{}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		path = append(path, token)
+
+		child, ok := known[token]
+		if !ok {
+			return strings.Join(path, "."), false
+		}
+
+		fields, ok := child.(map[string]interface{})
+		if !ok {
+			break
+		}
+		known = fields
+	}
+
+	return strings.Join(path, "."), true
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Actions recorded against a revision
+const (
+	RevisionCreated    = "create"
+	RevisionUpdated    = "update"
+	RevisionDeleted    = "delete"
+	RevisionRestored   = "restore"
+	RevisionRolledBack = "rollback"
+)
+
+// How many times to try for a free revision number when spells are being
+// edited at the same time
+const maxRevisionAttempts = 3
+
+// Revision is a snapshot of a spell after a change, numbered from 1 in the
+// order the changes were made
+type Revision struct {
+	SpellID primitive.ObjectID `json:"-" bson:"spellid"`
+	Number  int64              `json:"revision" bson:"revision"`
+	Action  string             `json:"action" bson:"action"`
+	Author  string             `json:"author,omitempty" bson:"author,omitempty"`
+	At      time.Time          `json:"at" bson:"at"`
+	Spell   Spell              `json:"spell" bson:"spell"`
+}
+
+// recordRevision adds a snapshot of a spell to its history. The change has
+// already been made by the time this is called, so callers don't fail the
+// request on an error but must trace and log it with warnRevisionError, as it
+// leaves a gap in the spell's history.
+func recordRevision(ctx context.Context, db Store, action string, spell Spell, author string) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "RecordRevision")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("RecordRevision.Action", action),
+		attribute.String("RecordRevision.SpellId", spell.ID.Hex()),
+		attribute.String("RecordRevision.Author", author),
+	)
+
+	if spell.ID.IsZero() {
+		span.SetAttributes(attribute.String("RecordRevision.Error", "MissingSpellId"))
+		return fmt.Errorf("failed to record %s revision: spell has no id", action)
+	}
+
+	for attempt := 0; attempt < maxRevisionAttempts; attempt++ {
+		revisions, err := getRevisions(ctx, db, spell.ID)
+		if err != nil {
+			span.SetAttributes(attribute.String("RecordRevision.Error", err.Error()))
+			return fmt.Errorf("failed to record %s revision of %s: %w", action, spell.ID.Hex(), err)
+		}
+
+		revision := Revision{
+			SpellID: spell.ID,
+			Number:  int64(len(revisions)) + 1,
+			Action:  action,
+			Author:  author,
+			At:      time.Now().UTC(),
+			Spell:   spell,
+		}
+		if len(revisions) > 0 {
+			revision.Number = revisions[len(revisions)-1].Number + 1
+		}
+
+		bsonRevision, err := bson.Marshal(revision)
+		if err != nil {
+			span.SetAttributes(attribute.String("RecordRevision.Error", err.Error()))
+			return fmt.Errorf("failed to record %s revision of %s: failed to marshall data: %w", action, spell.ID.Hex(), err)
+		}
+
+		// The unique index rejects a number taken by a concurrent edit, so
+		// look again for the latest revision
+		err = db.AddRevision(ctx, bsonRevision)
+		if errors.Is(err, ErrRevisionExists) {
+			continue
+		} else if err != nil {
+			span.SetAttributes(attribute.String("RecordRevision.Error", err.Error()))
+			return fmt.Errorf("failed to record %s revision of %s: %w", action, spell.ID.Hex(), err)
+		}
+
+		span.SetAttributes(attribute.Int64("RecordRevision.Number", revision.Number))
+		return nil
+	}
+
+	span.SetAttributes(attribute.String("RecordRevision.Error", ErrRevisionExists.Error()))
+	return fmt.Errorf("failed to record %s revision of %s after %d attempts: %w", action, spell.ID.Hex(), maxRevisionAttempts, ErrRevisionExists)
+}
+
+// warnRevisionError traces and logs a revision recordRevision couldn't add,
+// so a gap in a spell's history can be found and looked into
+func warnRevisionError(span trace.Span, name string, err error) {
+	if err == nil {
+		return
+	}
+
+	span.SetAttributes(attribute.String(name+".RevisionError", err.Error()))
+	log.Printf("spell history is missing a revision: %v", err)
+}
+
+// GetSpellRevisions returns the history of the spell matching name and query,
+// oldest first. A spell in the trash is found if there isn't one by that name
+// that hasn't been deleted.
+func GetSpellRevisions(ctx context.Context, db Store, name string, query url.Values) ([]Revision, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetSpellRevisions")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("GetSpellRevisions.SpellName", name),
+		attribute.String("GetSpellRevisions.RawQuery", query.Encode()),
+	)
+
+	existing, err := FindSpell(ctx, db, name, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisions.Error", err.Error()))
+		return nil, err
+	}
+
+	// A deleted spell's history is kept, so it can still be read while the
+	// spell is in the trash
+	if existing.Name == "" {
+		existing, err = findTrashedSpell(ctx, db, name, query)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellRevisions.Error", err.Error()))
+			return nil, err
+		}
+		span.SetAttributes(attribute.Bool("GetSpellRevisions.Trashed", true))
+	}
+
+	revisions, err := getRevisions(ctx, db, existing.ID)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevisions.Error", err.Error()))
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("GetSpellRevisions.ResultsCount", len(revisions)))
+
+	return revisions, nil
+}
+
+// GetSpellRevision returns a single revision of the spell matching name and
+// query
+func GetSpellRevision(ctx context.Context, db Store, name string, query url.Values, number int64) (Revision, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetSpellRevision")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int64("GetSpellRevision.Number", number))
+
+	revisions, err := GetSpellRevisions(ctx, db, name, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellRevision.Error", err.Error()))
+		return Revision{}, err
+	}
+
+	for _, r := range revisions {
+		if r.Number == number {
+			return r, nil
+		}
+	}
+
+	span.SetAttributes(attribute.String("GetSpellRevision.Error", "RevisionNotFound"))
+	return Revision{}, fmt.Errorf("revision %d: %w", number, ErrNotFound)
+}
+
+// RollbackSpell puts the spell matching name and query back the way it was at
+// an earlier revision. The rollback is itself recorded as a new revision.
+func RollbackSpell(ctx context.Context, db Store, name string, query url.Values, number int64, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "RollbackSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("RollbackSpell.SpellName", name),
+		attribute.Int64("RollbackSpell.Number", number),
+	)
+
+	existing, err := FindSpell(ctx, db, name, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("RollbackSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	if existing.Name == "" {
+		span.SetAttributes(attribute.String("RollbackSpell.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	revision, err := GetSpellRevision(ctx, db, name, query, number)
+	if err != nil {
+		span.SetAttributes(attribute.String("RollbackSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	spell, err := replaceExistingSpell(ctx, db, existing, revision.Spell, ifMatch, RevisionRolledBack, user)
+	if err != nil {
+		span.SetAttributes(attribute.String("RollbackSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	return spell, nil
+}
+
+func getRevisions(ctx context.Context, db Store, spellId primitive.ObjectID) ([]Revision, error) {
+	results, err := db.GetRevisions(ctx, spellId)
+	if err != nil {
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	revisions := []Revision{}
+	for _, v := range results {
+		temp, err := bson.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshall data: %w", err)
+		}
+
+		var r Revision
+		err = bson.Unmarshal(temp, &r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshall data: %w", err)
+		}
+		r.At = r.At.UTC()
+		revisions = append(revisions, r)
+	}
+
+	return revisions, nil
+}
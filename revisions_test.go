@@ -0,0 +1,101 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+)
+
+func TestSpellRevisions(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store, `{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`)
+
+	query := url.Values{"system": []string{"test1"}}
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"fireball","description":"Bigger boom","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	_, err = spellapi.ReplaceSpell(ctx, store, "fireball", query, spell, "", "editor")
+	if err != nil {
+		t.Fatalf("ReplaceSpell() err = %v; want nil", err)
+	}
+
+	_, err = spellapi.DeleteSpell(ctx, store, "fireball", query, "", "editor", false)
+	if err != nil {
+		t.Fatalf("DeleteSpell() err = %v; want nil", err)
+	}
+
+	// History can still be read while the spell is in the trash
+	deleted, err := spellapi.GetSpellRevisions(ctx, store, "fireball", query)
+	if err != nil || len(deleted) != 3 || deleted[2].Action != spellapi.RevisionDeleted {
+		t.Errorf("GetSpellRevisions() for a deleted spell = %+v, %v; want 3 revisions ending with the delete", deleted, err)
+	}
+
+	_, err = spellapi.GetSpellRevisions(ctx, store, "fire bolt", query)
+	if !errors.Is(err, spellapi.ErrNotFound) {
+		t.Errorf("GetSpellRevisions(fire bolt) err = %v; want %v", err, spellapi.ErrNotFound)
+	}
+
+	trashed, err := spellapi.GetTrash(ctx, store, query)
+	if err != nil || len(trashed) != 1 {
+		t.Fatalf("GetTrash() = %v, %v; want the deleted fireball", trashed, err)
+	}
+	_, err = spellapi.RestoreSpell(ctx, store, trashed[0].ID, "admin")
+	if err != nil {
+		t.Fatalf("RestoreSpell() err = %v; want nil", err)
+	}
+
+	rolledBack, err := spellapi.RollbackSpell(ctx, store, "fireball", query, 1, "", "admin")
+	if err != nil {
+		t.Fatalf("RollbackSpell() err = %v; want nil", err)
+	}
+	if rolledBack.Description != "Big boom" {
+		t.Errorf("RollbackSpell() returned %v; want the first revision", rolledBack)
+	}
+
+	revisions, err := spellapi.GetSpellRevisions(ctx, store, "fireball", query)
+	if err != nil {
+		t.Fatalf("GetSpellRevisions() err = %v; want nil", err)
+	}
+
+	want := []struct {
+		action      string
+		author      string
+		description string
+	}{
+		{spellapi.RevisionCreated, "tester", "Big boom"},
+		{spellapi.RevisionUpdated, "editor", "Bigger boom"},
+		{spellapi.RevisionDeleted, "editor", "Bigger boom"},
+		{spellapi.RevisionRestored, "admin", "Bigger boom"},
+		{spellapi.RevisionRolledBack, "admin", "Big boom"},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("GetSpellRevisions() returned %d revisions; want %d", len(revisions), len(want))
+	}
+	for i, v := range want {
+		r := revisions[i]
+		if r.Number != int64(i+1) || r.Action != v.action || r.Author != v.author || r.Spell.Description != v.description || r.At.IsZero() {
+			t.Errorf("revision %d = %+v; want %s by %s with %q", i+1, r, v.action, v.author, v.description)
+		}
+	}
+
+	got, err := spellapi.GetSpellRevision(ctx, store, "fireball", query, 2)
+	if err != nil || got.Spell.Description != "Bigger boom" {
+		t.Errorf("GetSpellRevision(2) = %+v, %v; want the update", got, err)
+	}
+
+	_, err = spellapi.GetSpellRevision(ctx, store, "fireball", query, 10)
+	if !errors.Is(err, spellapi.ErrNotFound) {
+		t.Errorf("GetSpellRevision(10) err = %v; want %v", err, spellapi.ErrNotFound)
+	}
+
+	_, err = spellapi.RollbackSpell(ctx, store, "fireball", query, 10, "", "admin")
+	if !errors.Is(err, spellapi.ErrNotFound) {
+		t.Errorf("RollbackSpell(10) err = %v; want %v", err, spellapi.ErrNotFound)
+	}
+}
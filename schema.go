@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Keywords checkSchema and validateSchema understand. This is the subset of
+// JSON Schema that's useful for describing spelldata, anything else is
+// rejected when a system is saved so a schema never silently checks less than
+// it appears to.
+var schemaKeywords = map[string]bool{
+	"type":                 true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"enum":                 true,
+	"const":                true,
+	"minimum":              true,
+	"maximum":              true,
+	"exclusiveMinimum":     true,
+	"exclusiveMaximum":     true,
+	"minLength":            true,
+	"maxLength":            true,
+	"pattern":              true,
+	"minItems":             true,
+	"maxItems":             true,
+	"uniqueItems":          true,
+}
+
+// Keywords that only describe a schema and are allowed but ignored
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"deprecated":  true,
+}
+
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// parseSchema decodes and checks a JSON Schema, returning a validation error
+// on field for anything wrong with it
+func parseSchema(field string, raw json.RawMessage) (interface{}, error) {
+	var schema interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, newValidationError(field, "invalid schema: %v", err)
+	}
+
+	if _, ok := schema.(map[string]interface{}); !ok {
+		return nil, newValidationError(field, "invalid schema: must be an object")
+	}
+
+	if err := checkSchema(schema, field); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// checkSchema makes sure every keyword in a schema is one validateSchema
+// understands and has a value it can use. path is where in the schema it is,
+// for the error.
+func checkSchema(schema interface{}, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return newValidationError(path, "invalid schema: %s must be an object or boolean", path)
+	}
+
+	for k, v := range s {
+		if schemaAnnotations[k] {
+			continue
+		}
+		if !schemaKeywords[k] {
+			return newValidationError(path, "invalid schema: %s.%s isn't supported", path, k)
+		}
+
+		keyword := fmt.Sprintf("%s.%s", path, k)
+		invalid := newValidationError(path, "invalid schema: %s has the wrong type", keyword)
+
+		switch k {
+		case "type":
+			types, ok := schemaTypeList(v)
+			if !ok {
+				return invalid
+			}
+			for _, t := range types {
+				if !schemaTypes[t] {
+					return newValidationError(path, "invalid schema: %s has unknown type %q", keyword, t)
+				}
+			}
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return invalid
+			}
+			for name, prop := range props {
+				if err := checkSchema(prop, fmt.Sprintf("%s.%s", keyword, name)); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items":
+			if err := checkSchema(v, keyword); err != nil {
+				return err
+			}
+		case "required":
+			list, ok := v.([]interface{})
+			if !ok {
+				return invalid
+			}
+			for _, r := range list {
+				if _, ok := r.(string); !ok {
+					return invalid
+				}
+			}
+		case "enum":
+			if _, ok := v.([]interface{}); !ok {
+				return invalid
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if _, ok := v.(float64); !ok {
+				return invalid
+			}
+		case "minLength", "maxLength", "minItems", "maxItems":
+			n, ok := v.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return invalid
+			}
+		case "pattern":
+			p, ok := v.(string)
+			if !ok {
+				return invalid
+			}
+			if _, err := regexp.Compile(p); err != nil {
+				return newValidationError(path, "invalid schema: %s: %v", keyword, err)
+			}
+		case "uniqueItems":
+			if _, ok := v.(bool); !ok {
+				return invalid
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSchema checks value against a schema that's already been through
+// checkSchema and returns every problem found, rather than stopping at the
+// first. field is the path to value in the spell.
+func validateSchema(schema interface{}, value interface{}, field string) []*ValidationError {
+	if allowed, ok := schema.(bool); ok {
+		if allowed {
+			return nil
+		}
+		return []*ValidationError{{Field: field, Message: "isn't allowed"}}
+	}
+
+	s := schema.(map[string]interface{})
+	errs := []*ValidationError{}
+	fail := func(format string, a ...interface{}) {
+		errs = append(errs, &ValidationError{Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if t, ok := s["type"]; ok {
+		types, _ := schemaTypeList(t)
+		matched := false
+		for _, want := range types {
+			if schemaTypeMatches(want, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must be %s", strings.Join(types, " or "))
+			// Nothing else means much once the type is wrong
+			return errs
+		}
+	}
+
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		fail("must be %s", schemaValue(c))
+	}
+
+	if e, ok := s["enum"]; ok {
+		options := e.([]interface{})
+		matched := false
+		for _, o := range options {
+			if reflect.DeepEqual(o, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			names := make([]string, len(options))
+			for i, o := range options {
+				names[i] = schemaValue(o)
+			}
+			fail("must be one of %s", strings.Join(names, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && v < min {
+			fail("must be at least %v", min)
+		}
+		if max, ok := s["maximum"].(float64); ok && v > max {
+			fail("must be at most %v", max)
+		}
+		if min, ok := s["exclusiveMinimum"].(float64); ok && v <= min {
+			fail("must be more than %v", min)
+		}
+		if max, ok := s["exclusiveMaximum"].(float64); ok && v >= max {
+			fail("must be less than %v", max)
+		}
+
+	case string:
+		length := len([]rune(v))
+		if min, ok := s["minLength"].(float64); ok && length < int(min) {
+			fail("must be at least %v characters", min)
+		}
+		if max, ok := s["maxLength"].(float64); ok && length > int(max) {
+			fail("must be at most %v characters", max)
+		}
+		if p, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(v) {
+				fail("must match %s", p)
+			}
+		}
+
+	case []interface{}:
+		if min, ok := s["minItems"].(float64); ok && len(v) < int(min) {
+			fail("must have at least %v items", min)
+		}
+		if max, ok := s["maxItems"].(float64); ok && len(v) > int(max) {
+			fail("must have at most %v items", max)
+		}
+		if unique, _ := s["uniqueItems"].(bool); unique {
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						fail("items must be unique, %s is repeated", schemaValue(v[i]))
+					}
+				}
+			}
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", field, i))...)
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				name := r.(string)
+				if _, ok := v[name]; !ok {
+					errs = append(errs, &ValidationError{
+						Field:   fmt.Sprintf("%s.%s", field, name),
+						Message: "missing required field",
+					})
+				}
+			}
+		}
+
+		props, _ := s["properties"].(map[string]interface{})
+		additional, hasAdditional := s["additionalProperties"]
+
+		// Sorted so the errors come back in the same order every time
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			path := fmt.Sprintf("%s.%s", field, k)
+			if prop, ok := props[k]; ok {
+				errs = append(errs, validateSchema(prop, v[k], path)...)
+			} else if hasAdditional {
+				errs = append(errs, validateSchema(additional, v[k], path)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// schemaTypeList returns the types allowed by a type keyword, which can be a
+// single name or a list of them
+func schemaTypeList(t interface{}) ([]string, bool) {
+	switch v := t.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		types := make([]string, len(v))
+		for i, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return nil, false
+			}
+			types[i] = s
+		}
+		return types, len(types) > 0
+	}
+
+	return nil, false
+}
+
+func schemaTypeMatches(want string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return want == "null"
+	case bool:
+		return want == "boolean"
+	case string:
+		return want == "string"
+	case float64:
+		return want == "number" || (want == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		return want == "array"
+	case map[string]interface{}:
+		return want == "object"
+	}
+
+	return false
+}
+
+// schemaValue formats a value from a schema for an error message
+func schemaValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
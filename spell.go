@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/launchdarkly/go-sdk-common.v2/lduser"
@@ -17,14 +23,35 @@ import (
 const (
 	MultipleMatchingSpells = "multiple matching spells found"
 	SpellAlreadyExists     = "spell already exists for this system"
+	SpellNotFound          = "spell not found"
+	InvalidCursor          = "invalid cursor"
 )
 
 type Store interface {
 	GetSpell(ctx context.Context, search bson.M) ([]bson.M, error)
+	GetSpellPage(ctx context.Context, search bson.M, skip int64, limit int64) ([]bson.M, error)
+	CountSpells(ctx context.Context, search bson.M) (int64, error)
+	SearchSpells(ctx context.Context, text string, search bson.M, limit int64) ([]bson.M, error)
+	GetSpellNames(ctx context.Context, search bson.M, limit int64) ([]bson.M, error)
 	AddSpell(ctx context.Context, spell []byte) error
+	AddSpells(ctx context.Context, spells [][]byte) []error
+	AddSpellsAtomic(ctx context.Context, spells [][]byte) ([]error, error)
+	SupportsTransactions() bool
+	UpdateSpell(ctx context.Context, search bson.M, spell []byte) error
 	DeleteSpell(ctx context.Context, spell bson.M) error
+	TrashSpell(ctx context.Context, search bson.M, deletedBy string, deletedAt time.Time) error
+	GetTrash(ctx context.Context, search bson.M) ([]bson.M, error)
+	RestoreSpell(ctx context.Context, id primitive.ObjectID) error
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+	AddRevision(ctx context.Context, revision []byte) error
+	GetRevisions(ctx context.Context, spellId primitive.ObjectID) ([]bson.M, error)
+	GetSystems(ctx context.Context, search bson.M) ([]bson.M, error)
+	AddSystem(ctx context.Context, system []byte) error
+	UpdateSystem(ctx context.Context, search bson.M, system []byte) error
+	DeleteSystem(ctx context.Context, search bson.M) error
 	GetMetadataValues(ctx context.Context, metadata string) ([]string, error)
 	GetMetadataNames(ctx context.Context) ([]string, error)
+	GetValueTypes(ctx context.Context, field string, search bson.M) ([]string, error)
 }
 
 type FeatureFlags interface {
@@ -34,15 +61,67 @@ type FeatureFlags interface {
 }
 
 type SpellService struct {
-	store Store
-	flags FeatureFlags
+	store        Store
+	flags        FeatureFlags
+	maxBodyBytes int64
 }
 
+// NewSpellService creates the service behind the API's handlers. A
+// maxBodyBytes of 0 uses the default limit.
+func NewSpellService(store Store, flags FeatureFlags, maxBodyBytes int64) SpellService {
+	return SpellService{
+		store:        store,
+		flags:        flags,
+		maxBodyBytes: maxBodyBytes,
+	}
+}
+
+// Spell is a single spell. ID is assigned when the spell is added and never
+// changes, so it's the one way to refer to a spell that survives a rename.
+// Version goes up by one with every change and makes up the ETag.
+//
+// Name is the name as it was given and Key is the normalized version spells
+// are looked up by. Key is stored as name so the existing indexes and
+// queries keep working. Aliases are other names the spell can be found by,
+// with AliasKeys normalized the same way as Key. MatchedAlias is set when
+// FindSpell found the spell by one of its aliases.
 type Spell struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	SpellData   map[string]interface{} `json:"spelldata,omitempty"`
-	Metadata    SpellMetadata          `json:"metadata,omitempty"`
+	ID           primitive.ObjectID     `json:"-" bson:"_id,omitempty"`
+	Version      int64                  `json:"-" bson:"version,omitempty"`
+	Key          string                 `json:"-" bson:"name"`
+	Name         string                 `json:"name" bson:"displayName"`
+	Aliases      []string               `json:"aliases,omitempty" bson:"aliases,omitempty"`
+	AliasKeys    []string               `json:"-" bson:"aliasKeys,omitempty"`
+	MatchedAlias string                 `json:"-" bson:"-"`
+	Description  string                 `json:"description"`
+	SpellData    map[string]interface{} `json:"spelldata,omitempty"`
+	Metadata     SpellMetadata          `json:"metadata,omitempty"`
+}
+
+// SpellPage is one page of results from GetSpellPage. Cursor is empty on the
+// last page.
+type SpellPage struct {
+	Data   []Spell `json:"data"`
+	Cursor string  `json:"cursor,omitempty"`
+	Next   string  `json:"next,omitempty"`
+	Total  *int64  `json:"total,omitempty"`
+}
+
+// SpellName identifies a spell without loading the rest of it
+type SpellName struct {
+	Name   string `json:"name"`
+	System string `json:"system"`
+}
+
+// SearchResult is a spell matching a text search and how relevant it is,
+// higher scores are better matches
+type SearchResult struct {
+	Score float64 `json:"score"`
+	Spell Spell   `json:"spell"`
+}
+
+type pageCursor struct {
+	Offset int64 `json:"offset"`
 }
 
 type Request struct {
@@ -62,33 +141,135 @@ type ErrorResponse struct {
 	ResponseCode int    `json:"responsecode"`
 }
 
+// SpellNotFoundResponse is the problem returned when a spell can't be found,
+// with suggestions for what might have been meant
+type SpellNotFoundResponse struct {
+	Problem
+	Suggestions []SpellSuggestion `json:"suggestions"`
+}
+
 func (s *Spell) UnmarshalJSON(data []byte) error {
 	var temp struct {
-		Name        string                 `json:"name"`
-		Description string                 `json:"description"`
-		SpellData   map[string]interface{} `json:"spelldata,omitempty"`
-		Metadata    SpellMetadata          `json:"metadata,omitempty"`
+		ID           primitive.ObjectID     `json:"-" bson:"_id,omitempty"`
+		Version      int64                  `json:"-" bson:"version,omitempty"`
+		Key          string                 `json:"-" bson:"name"`
+		Name         string                 `json:"name" bson:"displayName"`
+		Aliases      []string               `json:"aliases,omitempty" bson:"aliases,omitempty"`
+		AliasKeys    []string               `json:"-" bson:"aliasKeys,omitempty"`
+		MatchedAlias string                 `json:"-" bson:"-"`
+		Description  string                 `json:"description"`
+		SpellData    map[string]interface{} `json:"spelldata,omitempty"`
+		Metadata     SpellMetadata          `json:"metadata,omitempty"`
 	}
 	err := json.Unmarshal(data, &temp)
 	if err != nil {
 		return err
 	}
 
-	temp.Name = strings.ToLower(temp.Name)
 	*s = temp
+	s.setKeys()
+	return nil
+}
+
+// setKeys trims the name and aliases and works out the keys they're looked up
+// by
+func (s *Spell) setKeys() {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Key = normalizeName(s.Name)
+
+	s.AliasKeys = nil
+	for i, alias := range s.Aliases {
+		s.Aliases[i] = strings.TrimSpace(alias)
+		s.AliasKeys = append(s.AliasKeys, normalizeName(alias))
+	}
+}
+
+func (s *Spell) UnmarshalBSON(data []byte) error {
+	// A different type without this method so bson doesn't call it again
+	type storedSpell Spell
+	var temp storedSpell
+	err := bson.Unmarshal(data, &temp)
+	if err != nil {
+		return err
+	}
+
+	// Spells stored before display names were kept only have the lower case
+	// name until MigrateSpellNames has run
+	if temp.Name == "" {
+		temp.Name = guessDisplayName(temp.Key)
+	}
+
+	*s = Spell(temp)
 	return nil
 }
 
+// MarshalBSON stores the string values in spelldata together as searchText,
+// so the text index can cover spelldata without covering the metadata too
+func (s Spell) MarshalBSON() ([]byte, error) {
+	// A different type without this method so bson doesn't call it again
+	type storedSpell Spell
+	return bson.Marshal(struct {
+		Spell      storedSpell `bson:",inline"`
+		SearchText string      `bson:"searchText,omitempty"`
+	}{storedSpell(s), spellDataText(s.SpellData)})
+}
+
+// spellDataText joins every string in spelldata, including ones in lists and
+// nested objects, in key order
+func spellDataText(value interface{}) string {
+	texts := []string{}
+	switch v := value.(type) {
+	case string:
+		texts = append(texts, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			texts = append(texts, spellDataText(v[k]))
+		}
+	case bson.M:
+		return spellDataText(map[string]interface{}(v))
+	case bson.D:
+		return spellDataText(v.Map())
+	case []interface{}:
+		for _, item := range v {
+			texts = append(texts, spellDataText(item))
+		}
+	case bson.A:
+		return spellDataText([]interface{}(v))
+	}
+
+	nonEmpty := texts[:0]
+	for _, t := range texts {
+		if t != "" {
+			nonEmpty = append(nonEmpty, t)
+		}
+	}
+
+	return strings.Join(nonEmpty, "\n")
+}
+
 func (s Spell) MarshalJSON() ([]byte, error) {
 
 	var temp struct {
-		Name        string                 `json:"name" bson:"name"`
-		Description string                 `json:"description" bson:"description"`
-		SpellData   map[string]interface{} `json:"spelldata,omitempty" bson:"spelldata,omitempty"`
-		Metadata    SpellMetadata          `json:"metadata" bson:"metadata"`
+		ID           string                 `json:"id,omitempty" bson:"-"`
+		Name         string                 `json:"name" bson:"name"`
+		Aliases      []string               `json:"aliases,omitempty" bson:"aliases,omitempty"`
+		MatchedAlias string                 `json:"matchedAlias,omitempty" bson:"-"`
+		Description  string                 `json:"description" bson:"description"`
+		SpellData    map[string]interface{} `json:"spelldata,omitempty" bson:"spelldata,omitempty"`
+		Metadata     SpellMetadata          `json:"metadata" bson:"metadata"`
 	}
 
-	temp.Name = strings.Title(s.Name)
+	if !s.ID.IsZero() {
+		temp.ID = s.ID.Hex()
+	}
+	temp.Name = s.Name
+	temp.Aliases = s.Aliases
+	temp.MatchedAlias = s.MatchedAlias
 	temp.Description = s.Description
 	temp.SpellData = s.SpellData
 	temp.Metadata = s.Metadata
@@ -102,18 +283,36 @@ func (s Spell) String() string {
 	return string(json)
 }
 
+// SpellMetadata is information about a spell rather than part of it. Creator,
+// CreatedAt and UpdatedAt are set by the API and ignored if sent in a request.
 type SpellMetadata struct {
-	System  string `json:"system" bson:"system"`
-	Creator string `json:"creator,omitempty" bson:"creator,omitempty"`
+	System    string    `json:"system" bson:"system"`
+	Creator   string    `json:"creator,omitempty" bson:"creator,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt,omitempty"`
 }
 
 func (smd SpellMetadata) MarshalJSON() ([]byte, error) {
 
 	var temp struct {
-		System string `json:"system"`
+		System    string     `json:"system"`
+		Creator   string     `json:"creator,omitempty"`
+		CreatedAt *time.Time `json:"createdAt,omitempty"`
+		UpdatedAt *time.Time `json:"updatedAt,omitempty"`
 	}
 
 	temp.System = smd.System
+	temp.Creator = smd.Creator
+
+	// Spells from before these were recorded don't have them
+	if !smd.CreatedAt.IsZero() {
+		createdAt := smd.CreatedAt.UTC()
+		temp.CreatedAt = &createdAt
+	}
+	if !smd.UpdatedAt.IsZero() {
+		updatedAt := smd.UpdatedAt.UTC()
+		temp.UpdatedAt = &updatedAt
+	}
 
 	return json.Marshal(temp)
 }
@@ -134,22 +333,18 @@ func FindSpell(ctx context.Context, db Store, name string, query url.Values) (Sp
 		attribute.String("FindSpell.RawQuery", query.Encode()),
 	)
 
+	key := normalizeName(name)
 	bsonQuery := bson.M{
-		"name": bson.M{
-			"$eq": strings.ToLower(name),
+		"$or": []bson.M{
+			{"name": bson.M{"$eq": key}},
+			{"aliasKeys": bson.M{"$eq": key}},
 		},
 	}
 
-	for k, v := range query {
-		if k == "system" {
-			bsonQuery["metadata.system"] = bson.M{
-				"$eq": v[0],
-			}
-		} else {
-			bsonQuery[(fmt.Sprintf("spelldata.%s", k))] = bson.M{
-				"$in": v,
-			}
-		}
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSpell.Error", err.Error()))
+		return Spell{}, err
 	}
 
 	span.SetAttributes(attribute.String("FindSpell.BsonQuery", fmt.Sprintf("%v", bsonQuery)))
@@ -157,7 +352,7 @@ func FindSpell(ctx context.Context, db Store, name string, query url.Values) (Sp
 	results, err := db.GetSpell(ctx, bsonQuery)
 	if err != nil {
 		span.SetAttributes(attribute.String("FindSpell.Error", err.Error()))
-		return Spell{}, fmt.Errorf("query failed on DB: %v", err)
+		return Spell{}, fmt.Errorf("query failed on DB: %w", err)
 	}
 
 	span.SetAttributes(
@@ -167,63 +362,491 @@ func FindSpell(ctx context.Context, db Store, name string, query url.Values) (Sp
 
 	if len(results) == 0 {
 		return Spell{}, nil
-	} else if len(results) > 1 {
-		return Spell{}, fmt.Errorf(MultipleMatchingSpells)
 	}
 
-	var s Spell
-	temp, err := bson.Marshal(results[0])
+	spells, err := decodeSpells(results)
 	if err != nil {
 		span.SetAttributes(attribute.String("FindSpell.Error", err.Error()))
-		return Spell{}, fmt.Errorf("failed to marshall data: %v", err)
+		return Spell{}, err
 	}
 
-	err = bson.Unmarshal(temp, &s)
-	if err != nil {
-		span.SetAttributes(attribute.String("FindSpell.error", err.Error()))
-		return Spell{}, fmt.Errorf("failed to unmarshall data: %v", err)
+	// A spell's own name wins over another spell's alias, which can only
+	// happen when they're in different systems
+	if len(spells) > 1 {
+		named := []Spell{}
+		for _, s := range spells {
+			if s.Key == key {
+				named = append(named, s)
+			}
+		}
+		spells = named
+	}
+
+	if len(spells) != 1 {
+		return Spell{}, ErrAmbiguousMatch
+	}
+
+	s := spells[0]
+	if s.Key != key {
+		for i, aliasKey := range s.AliasKeys {
+			if aliasKey == key && i < len(s.Aliases) {
+				s.MatchedAlias = s.Aliases[i]
+				span.SetAttributes(attribute.String("FindSpell.MatchedAlias", s.MatchedAlias))
+			}
+		}
 	}
 
 	return s, nil
 }
 
-func AddSpell(ctx context.Context, db Store, spell Spell) error {
+// FindSpellById looks up a spell by the ID it was given when it was added. An
+// empty Spell is returned if there isn't one, the same as FindSpell.
+func FindSpellById(ctx context.Context, db Store, id string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "FindSpellById")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("FindSpellById.Id", id))
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSpellById.Error", err.Error()))
+		return Spell{}, newValidationError("id", "invalid id: %s", id)
+	}
+
+	results, err := db.GetSpell(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSpellById.Error", err.Error()))
+		return Spell{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("FindSpellById.ResultsCount", len(results)))
+
+	if len(results) == 0 {
+		return Spell{}, nil
+	}
+
+	spells, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSpellById.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	return spells[0], nil
+}
+
+// AddSpell stores a new spell and returns it with the ID it was given
+func AddSpell(ctx context.Context, db Store, spell Spell, user string) (Spell, error) {
 	tracer := otel.Tracer("Encantus")
 	ctx, span := tracer.Start(ctx, "AddSpell")
 	defer span.End()
 
 	span.SetAttributes(attribute.Stringer("AddSpell.Spell", spell))
 
-	queryValues := url.Values{"system": []string{spell.Metadata.System}}
-	exists, err := FindSpell(ctx, db, spell.Name, queryValues)
+	spell, err := prepareNewSpell(ctx, db, spell, user)
 	if err != nil {
 		span.SetAttributes(attribute.String("AddSpell.Error", err.Error()))
-		return fmt.Errorf("failed to check for existing spells: %v", err)
+		return Spell{}, err
 	}
 
-	span.SetAttributes(attribute.Stringer("AddSpell.Existing", exists))
+	err = insertSpell(ctx, db, spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("AddSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	err = recordRevision(ctx, db, RevisionCreated, spell, user)
+	warnRevisionError(span, "AddSpell", err)
+
+	return spell, nil
+}
+
+// prepareNewSpell checks a spell doesn't collide with any that already exist
+// and fills in everything the API sets on a new spell
+func prepareNewSpell(ctx context.Context, db Store, spell Spell, user string) (Spell, error) {
+	spell.setKeys()
+	spell.ID = primitive.NilObjectID
+
+	err := checkSpellCharacters(spell, nil)
+	if err != nil {
+		return Spell{}, err
+	}
 
-	if exists.Name == spell.Name {
-		span.SetAttributes(attribute.String("AddSpell.Error", SpellAlreadyExists))
-		return fmt.Errorf(SpellAlreadyExists)
+	err = checkNameConflicts(ctx, db, spell)
+	if err != nil {
+		return Spell{}, err
 	}
 
+	stampNewSpell(&spell, user)
+
+	return spell, nil
+}
+
+// stampNewSpell fills in everything the API sets on a new spell
+func stampNewSpell(spell *Spell, user string) {
+	// IDs are never taken from the caller. Pick it here rather than leaving
+	// it to the DB so the first revision can refer to it.
+	spell.ID = primitive.NewObjectID()
+	spell.Version = 1
+	spell.Metadata.Creator = user
+	spell.Metadata.CreatedAt = timestamp()
+	spell.Metadata.UpdatedAt = spell.Metadata.CreatedAt
+}
+
+// insertSpell writes a spell from prepareNewSpell to the DB
+func insertSpell(ctx context.Context, db Store, spell Spell) error {
 	bsonSpell, err := bson.Marshal(spell)
 	if err != nil {
-		span.SetAttributes(attribute.String("AddSpell.Error", err.Error()))
-		return fmt.Errorf("failed to marshall data: %v", err)
+		return fmt.Errorf("failed to marshall data: %w", err)
 	}
 
+	// The unique index catches anything added since the check in
+	// prepareNewSpell
 	err = db.AddSpell(ctx, bsonSpell)
+	if errors.Is(err, ErrConflict) {
+		return ErrConflict
+	} else if err != nil {
+		return fmt.Errorf("failed to add spell to DB: %w", err)
+	}
+
+	return nil
+}
+
+func ReplaceSpell(ctx context.Context, db Store, name string, query url.Values, spell Spell, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ReplaceSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("ReplaceSpell.SpellName", name),
+		attribute.Stringer("ReplaceSpell.Spell", spell),
+	)
+
+	existing, err := FindSpell(ctx, db, name, query)
 	if err != nil {
-		span.SetAttributes(attribute.String("AddSpell.Error", err.Error()))
-		return fmt.Errorf("failed to add spell to DB: %v", err)
+		span.SetAttributes(attribute.String("ReplaceSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	span.SetAttributes(attribute.Stringer("ReplaceSpell.Existing", existing))
+
+	if existing.Name == "" {
+		span.SetAttributes(attribute.String("ReplaceSpell.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	return replaceExistingSpell(ctx, db, existing, spell, ifMatch, RevisionUpdated, user)
+}
+
+// ReplaceSpellById works the same as ReplaceSpell for the spell with the given
+// ID, so the spell keeps its ID even if the new version renames it
+func ReplaceSpellById(ctx context.Context, db Store, id string, spell Spell, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ReplaceSpellById")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("ReplaceSpellById.Id", id),
+		attribute.Stringer("ReplaceSpellById.Spell", spell),
+	)
+
+	existing, err := FindSpellById(ctx, db, id)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceSpellById.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	if existing.Name == "" {
+		span.SetAttributes(attribute.String("ReplaceSpellById.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	return replaceExistingSpell(ctx, db, existing, spell, ifMatch, RevisionUpdated, user)
+}
+
+func PatchSpell(ctx context.Context, db Store, name string, query url.Values, patch []byte, contentType string, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "PatchSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("PatchSpell.SpellName", name),
+		attribute.String("PatchSpell.ContentType", contentType),
+		attribute.String("PatchSpell.Patch", string(patch)),
+	)
+
+	existing, err := FindSpell(ctx, db, name, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	span.SetAttributes(attribute.Stringer("PatchSpell.Existing", existing))
+
+	if existing.Name == "" {
+		span.SetAttributes(attribute.String("PatchSpell.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	return patchExistingSpell(ctx, db, existing, patch, contentType, ifMatch, user)
+}
+
+// PatchSpellById works the same as PatchSpell for the spell with the given ID
+func PatchSpellById(ctx context.Context, db Store, id string, patch []byte, contentType string, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "PatchSpellById")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("PatchSpellById.Id", id),
+		attribute.String("PatchSpellById.ContentType", contentType),
+		attribute.String("PatchSpellById.Patch", string(patch)),
+	)
+
+	existing, err := FindSpellById(ctx, db, id)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchSpellById.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	if existing.Name == "" {
+		span.SetAttributes(attribute.String("PatchSpellById.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	return patchExistingSpell(ctx, db, existing, patch, contentType, ifMatch, user)
+}
+
+// patchExistingSpell applies a patch to a spell that has already been looked
+// up and saves the result
+func patchExistingSpell(ctx context.Context, db Store, existing Spell, patch []byte, contentType string, ifMatch string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "PatchExistingSpell")
+	defer span.End()
+
+	// Check before applying the patch so a stale one fails with 412 rather
+	// than a confusing validation error
+	err := checkIfMatch(existing, ifMatch)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal([]byte(existing.String()), &doc)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to unmarshall data: %w", err)
+	}
+
+	switch contentType {
+	case MergePatchContentType:
+		var mergePatch interface{}
+		err = json.Unmarshal(patch, &mergePatch)
+		doc = applyMergePatch(doc, mergePatch)
+	case JSONPatchContentType:
+		doc, err = applyJSONPatch(doc, patch)
+	default:
+		err = fmt.Errorf("unsupported patch format %q", contentType)
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, newValidationError("", "%s: %v", InvalidPatch, err)
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to marshall data: %w", err)
+	}
+
+	spell, err := ParseSpell(ctx, db, patched)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("%s: %w", InvalidPatch, err)
+	}
+
+	span.SetAttributes(attribute.Stringer("PatchExistingSpell.Patched", spell))
+
+	spell, err = replaceExistingSpell(ctx, db, existing, spell, ifMatch, RevisionUpdated, user)
+	if err != nil {
+		span.SetAttributes(attribute.String("PatchExistingSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	return spell, nil
+}
+
+// nameKeys returns the keys of the name and every alias of a spell, along with
+// the names they came from
+func (s Spell) nameKeys() ([]string, []string) {
+	keys := append([]string{s.Key}, s.AliasKeys...)
+	names := append([]string{s.Name}, s.Aliases...)
+
+	return keys, names
+}
+
+// checkDuplicateAliases makes sure aliases don't repeat the name or each
+// other
+func checkDuplicateAliases(spell Spell) error {
+	keys, names := spell.nameKeys()
+	for i := range keys {
+		for j := 0; j < i; j++ {
+			if keys[i] == keys[j] {
+				return newValidationError("aliases", "alias %q is the same as %q", names[i], names[j])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNameConflicts makes sure neither the name nor any of the aliases of a
+// spell are used as the name or an alias of another spell in the same system.
+// A spell with no ID yet is checked against every spell.
+func checkNameConflicts(ctx context.Context, db Store, spell Spell) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "CheckNameConflicts")
+	defer span.End()
+
+	keys, names := spell.nameKeys()
+
+	span.SetAttributes(attribute.String("CheckNameConflicts.Keys", strings.Join(keys, ",")))
+
+	err := checkDuplicateAliases(spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckNameConflicts.Error", "DuplicateAlias"))
+		return err
+	}
+
+	bsonQuery := bson.M{
+		"metadata.system": bson.M{
+			"$eq": spell.Metadata.System,
+		},
+		"$or": []bson.M{
+			{"name": bson.M{"$in": keys}},
+			{"aliasKeys": bson.M{"$in": keys}},
+		},
+	}
+
+	results, err := db.GetSpell(ctx, bsonQuery)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckNameConflicts.Error", err.Error()))
+		return fmt.Errorf("failed to check for existing spells: %w", err)
+	}
+
+	others, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckNameConflicts.Error", err.Error()))
+		return err
+	}
+
+	for _, other := range others {
+		if !spell.ID.IsZero() && other.ID == spell.ID {
+			continue
+		}
+
+		span.SetAttributes(attribute.String("CheckNameConflicts.Error", SpellAlreadyExists))
+
+		if other.Key == spell.Key {
+			return ErrConflict
+		}
+
+		used := append([]string{other.Key}, other.AliasKeys...)
+		for i, k := range keys {
+			for _, u := range used {
+				if k == u {
+					return fmt.Errorf("%w: %q is already used by %q", ErrConflict, names[i], other.Name)
+				}
+			}
+		}
+
+		return ErrConflict
 	}
 
 	return nil
 }
 
-func ParseSpell(ctx context.Context, in []byte) (Spell, error) {
+// replaceExistingSpell overwrites a spell that has already been looked up,
+// checking that any rename doesn't collide with another spell and that it
+// hasn't been changed since it was read. The change is recorded as a revision
+// with the given action and the spell as stored is returned.
+func replaceExistingSpell(ctx context.Context, db Store, existing Spell, spell Spell, ifMatch string, action string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ReplaceExistingSpell")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.Stringer("ReplaceExistingSpell.Existing", existing),
+		attribute.Stringer("ReplaceExistingSpell.Spell", spell),
+	)
+
+	err := checkIfMatch(existing, ifMatch)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	spell.setKeys()
+	spell.ID = existing.ID
+
+	// A rollback puts back a version that was already accepted
+	if action != RevisionRolledBack {
+		err = checkSpellCharacters(spell, &existing)
+		if err != nil {
+			span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+			return Spell{}, err
+		}
+	}
+
+	// Renaming a spell, changing its aliases or moving it to another system
+	// mustn't collide with one that's already there
+	err = checkNameConflicts(ctx, db, spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	spell.MatchedAlias = ""
+	spell.Metadata.Creator = existing.Metadata.Creator
+	spell.Metadata.CreatedAt = existing.Metadata.CreatedAt
+	spell.Metadata.UpdatedAt = timestamp()
+	spell.Version = existing.Version + 1
+
+	bsonSpell, err := bson.Marshal(spell)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to marshall data: %w", err)
+	}
+
+	err = db.UpdateSpell(ctx, versionFilter(existing), bsonSpell)
+	if errors.Is(err, ErrNotFound) {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, ErrPreconditionFailed
+	} else if errors.Is(err, ErrConflict) {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, err
+	} else if err != nil {
+		span.SetAttributes(attribute.String("ReplaceExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to update spell in DB: %w", err)
+	}
+
+	err = recordRevision(ctx, db, action, spell, user)
+	warnRevisionError(span, "ReplaceExistingSpell", err)
+
+	return spell, nil
+}
+
+// timestamp is the current time at the precision the DB stores, so a spell
+// returned after a change matches the one read back later
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// ParseSpell reads a spell from a request body and checks it has everything a
+// spell needs. If its system is registered it's given the system's canonical
+// name and its spelldata is checked against the system's schema.
+func ParseSpell(ctx context.Context, db Store, in []byte) (Spell, error) {
 
 	tracer := otel.Tracer("Encantus")
 	ctx, span := tracer.Start(ctx, "ParseSpell")
@@ -233,54 +856,128 @@ func ParseSpell(ctx context.Context, in []byte) (Spell, error) {
 	err := json.Unmarshal(in, &s)
 	if err != nil {
 		span.SetAttributes(attribute.String("ParseSpell.Error", err.Error()))
-		return Spell{}, err
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return Spell{}, newValidationError(typeErr.Field, "invalid value for field: %s", typeErr.Field)
+		}
+		return Spell{}, newValidationError("", "invalid spell: %v", err)
 	}
 
-	if s.Name == "" {
+	if s.Key == "" {
 		span.SetAttributes(attribute.String("PostSpellHandler.MissingField", "Name"))
-		return s, fmt.Errorf("missing required field: name")
+		return s, newValidationError("name", "missing required field: name")
 	} else if s.Description == "" {
 		span.SetAttributes(attribute.String("PostSpellHandler.MissingField", "Description"))
-		return s, fmt.Errorf("missing required field: description")
+		return s, newValidationError("description", "missing required field: description")
 	} else if s.Metadata.System == "" {
 		span.SetAttributes(attribute.String("PostSpellHandler.MissingField", "System"))
-		return s, fmt.Errorf("missing required field: system")
+		return s, newValidationError("system", "missing required field: system")
+	}
+
+	for _, aliasKey := range s.AliasKeys {
+		if aliasKey == "" {
+			span.SetAttributes(attribute.String("ParseSpell.Error", "EmptyAlias"))
+			return s, newValidationError("aliases", "aliases can't be empty")
+		}
+	}
+
+	err = applySystem(ctx, db, &s)
+	if err != nil {
+		span.SetAttributes(attribute.String("ParseSpell.Error", err.Error()))
+		return s, err
 	}
 
 	return s, nil
 }
 
-func DeleteSpell(ctx context.Context, db Store, spell string, query url.Values) error {
+// DeleteSpell moves the one spell matching name and query to the trash and
+// returns it. With dryRun the spell is only looked up, to show what would be
+// deleted.
+func DeleteSpell(ctx context.Context, db Store, spell string, query url.Values, ifMatch string, deletedBy string, dryRun bool) (Spell, error) {
 	tracer := otel.Tracer("Encantus")
 	ctx, span := tracer.Start(ctx, "DeleteSpell")
 	defer span.End()
 
-	span.SetAttributes(attribute.String("DeleteSpell.SpellName", spell))
+	span.SetAttributes(
+		attribute.String("DeleteSpell.SpellName", spell),
+		attribute.String("DeleteSpell.DeletedBy", deletedBy),
+		attribute.Bool("DeleteSpell.DryRun", dryRun),
+	)
 
 	exists, err := FindSpell(ctx, db, spell, query)
 	if err != nil {
 		span.SetAttributes(attribute.String("DeleteSpell.Error", err.Error()))
-		return fmt.Errorf("failed to check for existing spells: %v", err)
+		return Spell{}, err
 	}
 
 	span.SetAttributes(attribute.Stringer("DeleteSpell.Existing", exists))
 
-	bsonQuery := bson.M{
-		"name": bson.M{
-			"$eq": exists.Name,
-		},
-		"metadata.system": bson.M{
-			"$eq": exists.Metadata.System,
-		},
+	if exists.Name == "" {
+		span.SetAttributes(attribute.String("DeleteSpell.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	return deleteExistingSpell(ctx, db, exists, ifMatch, deletedBy, dryRun)
+}
+
+// DeleteSpellById works the same as DeleteSpell for the spell with the given
+// ID
+func DeleteSpellById(ctx context.Context, db Store, id string, ifMatch string, deletedBy string, dryRun bool) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "DeleteSpellById")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("DeleteSpellById.Id", id),
+		attribute.String("DeleteSpellById.DeletedBy", deletedBy),
+		attribute.Bool("DeleteSpellById.DryRun", dryRun),
+	)
+
+	exists, err := FindSpellById(ctx, db, id)
+	if err != nil {
+		span.SetAttributes(attribute.String("DeleteSpellById.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	if exists.Name == "" {
+		span.SetAttributes(attribute.String("DeleteSpellById.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
 	}
 
-	err = db.DeleteSpell(ctx, bsonQuery)
+	return deleteExistingSpell(ctx, db, exists, ifMatch, deletedBy, dryRun)
+}
+
+func deleteExistingSpell(ctx context.Context, db Store, exists Spell, ifMatch string, deletedBy string, dryRun bool) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "DeleteExistingSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.Stringer("DeleteExistingSpell.Existing", exists))
+
+	err := checkIfMatch(exists, ifMatch)
 	if err != nil {
-		span.SetAttributes(attribute.String("DeleteSpell.Error", err.Error()))
-		return fmt.Errorf("failed to delete spell from DB: %v", err)
+		span.SetAttributes(attribute.String("DeleteExistingSpell.Error", err.Error()))
+		return Spell{}, err
 	}
 
-	return nil
+	if dryRun {
+		return exists, nil
+	}
+
+	err = db.TrashSpell(ctx, versionFilter(exists), deletedBy, time.Now())
+	if errors.Is(err, ErrNotFound) {
+		span.SetAttributes(attribute.String("DeleteExistingSpell.Error", err.Error()))
+		return Spell{}, ErrPreconditionFailed
+	} else if err != nil {
+		span.SetAttributes(attribute.String("DeleteExistingSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to delete spell from DB: %w", err)
+	}
+
+	err = recordRevision(ctx, db, RevisionDeleted, exists, deletedBy)
+	warnRevisionError(span, "DeleteExistingSpell", err)
+
+	return exists, nil
 }
 
 func GetAllSpell(ctx context.Context, db Store, query url.Values) ([]Spell, error) {
@@ -292,16 +989,10 @@ func GetAllSpell(ctx context.Context, db Store, query url.Values) ([]Spell, erro
 
 	bsonQuery := bson.M{}
 
-	for k, v := range query {
-		if k == "system" {
-			bsonQuery["metadata.system"] = bson.M{
-				"$eq": v[0],
-			}
-		} else {
-			bsonQuery[(fmt.Sprintf("spelldata.%s", k))] = bson.M{
-				"$in": v,
-			}
-		}
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetAllSpell.Error", err.Error()))
+		return []Spell{}, err
 	}
 
 	span.SetAttributes(attribute.String("GetAllSpell.BsonQuery", fmt.Sprintf("%v", bsonQuery)))
@@ -309,7 +1000,7 @@ func GetAllSpell(ctx context.Context, db Store, query url.Values) ([]Spell, erro
 	results, err := db.GetSpell(ctx, bsonQuery)
 	if err != nil {
 		span.SetAttributes(attribute.String("GetAllSpell.Error", err.Error()))
-		return []Spell{}, fmt.Errorf("query failed on DB: %v", err)
+		return []Spell{}, fmt.Errorf("query failed on DB: %w", err)
 	}
 
 	span.SetAttributes(attribute.Int("GetAllSpell.ResultsCount", len(results)))
@@ -319,20 +1010,214 @@ func GetAllSpell(ctx context.Context, db Store, query url.Values) ([]Spell, erro
 		return []Spell{}, nil
 	}
 
-	var s []Spell
+	s, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetAllSpell.Error", err.Error()))
+		return []Spell{}, err
+	}
+
+	return s, nil
+}
+
+// GetSpellPage returns up to limit spells matching the query, starting from
+// the position in cursor. An empty cursor starts from the first spell.
+func GetSpellPage(ctx context.Context, db Store, query url.Values, limit int64, cursor string, withTotal bool) (SpellPage, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetSpellPage")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("GetSpellPage.RawQuery", query.Encode()),
+		attribute.Int64("GetSpellPage.Limit", limit),
+		attribute.String("GetSpellPage.Cursor", cursor),
+	)
+
+	var position pageCursor
+	if cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			err = json.Unmarshal(raw, &position)
+		}
+		if err != nil || position.Offset < 0 {
+			span.SetAttributes(attribute.String("GetSpellPage.Error", InvalidCursor))
+			return SpellPage{}, newValidationError("cursor", InvalidCursor)
+		}
+	}
+
+	bsonQuery := bson.M{}
+
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellPage.Error", err.Error()))
+		return SpellPage{}, err
+	}
+
+	span.SetAttributes(attribute.String("GetSpellPage.BsonQuery", fmt.Sprintf("%v", bsonQuery)))
+
+	// Ask for one extra spell to find out if there's another page
+	results, err := db.GetSpellPage(ctx, bsonQuery, position.Offset, limit+1)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellPage.Error", err.Error()))
+		return SpellPage{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	page := SpellPage{}
+	if int64(len(results)) > limit {
+		results = results[:limit]
+
+		next, err := json.Marshal(pageCursor{Offset: position.Offset + limit})
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellPage.Error", err.Error()))
+			return SpellPage{}, fmt.Errorf("failed to marshall cursor: %w", err)
+		}
+		page.Cursor = base64.RawURLEncoding.EncodeToString(next)
+	}
+
+	page.Data, err = decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSpellPage.Error", err.Error()))
+		return SpellPage{}, err
+	}
+
+	if withTotal {
+		total, err := db.CountSpells(ctx, bsonQuery)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellPage.Error", err.Error()))
+			return SpellPage{}, fmt.Errorf("count failed on DB: %w", err)
+		}
+		page.Total = &total
+	}
+
+	span.SetAttributes(
+		attribute.Int("GetSpellPage.ResultsCount", len(page.Data)),
+		attribute.String("GetSpellPage.NextCursor", page.Cursor),
+	)
+
+	return page, nil
+}
+
+// SearchSpells finds spells whose name, description or spelldata contain the
+// search text, most relevant first. The query can narrow the search down the
+// same as GetAllSpell, usually to a single system.
+func SearchSpells(ctx context.Context, db Store, text string, query url.Values, limit int64) ([]SearchResult, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "SearchSpells")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("SearchSpells.Text", text),
+		attribute.String("SearchSpells.RawQuery", query.Encode()),
+		attribute.Int64("SearchSpells.Limit", limit),
+	)
+
+	if strings.TrimSpace(text) == "" {
+		span.SetAttributes(attribute.String("SearchSpells.Error", "MissingSearchText"))
+		return nil, newValidationError("q", "%s: missing search text", InvalidQuery)
+	}
+
+	bsonQuery := bson.M{}
+
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("SearchSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	results, err := db.SearchSpells(ctx, text, bsonQuery, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("SearchSpells.Error", err.Error()))
+		return nil, fmt.Errorf("search failed on DB: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("SearchSpells.ResultsCount", len(results)))
+
+	spells, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("SearchSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	matches := make([]SearchResult, len(spells))
+	for i, spell := range spells {
+		score, _ := results[i]["score"].(float64)
+		matches[i] = SearchResult{Score: score, Spell: spell}
+	}
+
+	return matches, nil
+}
+
+// AutocompleteSpells returns up to limit spells whose names start with prefix,
+// in name order. It only loads names so it's cheap enough to call on every
+// keystroke.
+func AutocompleteSpells(ctx context.Context, db Store, prefix string, system string, limit int64) ([]SpellName, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "AutocompleteSpells")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("AutocompleteSpells.Prefix", prefix),
+		attribute.String("AutocompleteSpells.System", system),
+		attribute.Int64("AutocompleteSpells.Limit", limit),
+	)
+
+	// Lookup keys are normalized so a case sensitive regex anchored to the
+	// start can use the index
+	bsonQuery := bson.M{
+		"name": bson.M{
+			"$regex": "^" + regexp.QuoteMeta(normalizeName(prefix)),
+		},
+	}
+
+	if system != "" {
+		systems, err := systemSpellings(ctx, db, system)
+		if err != nil {
+			span.SetAttributes(attribute.String("AutocompleteSpells.Error", err.Error()))
+			return nil, err
+		}
+
+		bsonQuery["metadata.system"] = bson.M{
+			"$in": systems,
+		}
+	}
+
+	results, err := db.GetSpellNames(ctx, bsonQuery, limit)
+	if err != nil {
+		span.SetAttributes(attribute.String("AutocompleteSpells.Error", err.Error()))
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	spells, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("AutocompleteSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	names := make([]SpellName, len(spells))
+	for i, spell := range spells {
+		names[i] = SpellName{
+			Name:   spell.Name,
+			System: spell.Metadata.System,
+		}
+	}
+
+	span.SetAttributes(attribute.Int("AutocompleteSpells.ResultsCount", len(names)))
+
+	return names, nil
+}
+
+func decodeSpells(results []bson.M) ([]Spell, error) {
+	s := []Spell{}
 
 	for _, v := range results {
 		temp, err := bson.Marshal(v)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetAllSpell.Error", err.Error()))
-			return []Spell{}, fmt.Errorf("failed to marshall data: %v", err)
+			return []Spell{}, fmt.Errorf("failed to marshall data: %w", err)
 		}
 
 		var tempSpell Spell
 		err = bson.Unmarshal(temp, &tempSpell)
 		if err != nil {
-			span.SetAttributes(attribute.String("GetAllSpell.error", err.Error()))
-			return []Spell{}, fmt.Errorf("failed to unmarshall data: %v", err)
+			return []Spell{}, fmt.Errorf("failed to unmarshall data: %w", err)
 		}
 		s = append(s, tempSpell)
 	}
@@ -356,7 +1241,18 @@ func GetSpellMetadata(ctx context.Context, db Store, metadataName string) ([]str
 	results, err := db.GetMetadataValues(ctx, metadataName)
 	if err != nil {
 		span.SetAttributes(attribute.String("GetSpellMetadata.error", err.Error()))
-		return nil, fmt.Errorf("failed to get metadata: %v", err)
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	// Spells saved under a system's alias before it was registered are
+	// counted as the system itself
+	if metadataName == "metadata.system" {
+		names, err := systemNames(ctx, db)
+		if err != nil {
+			span.SetAttributes(attribute.String("GetSpellMetadata.error", err.Error()))
+			return nil, fmt.Errorf("failed to get systems: %w", err)
+		}
+		results = canonicalSystems(results, names)
 	}
 
 	span.SetAttributes(attribute.String("GetSpellMetadata.Results", fmt.Sprintf("%v", results)))
@@ -374,7 +1270,7 @@ func GetAllSpellMetadata(ctx context.Context, db Store, query url.Values) ([]str
 	res, err := db.GetMetadataNames(ctx)
 	if err != nil {
 		span.SetAttributes(attribute.String("GetAllSpellMetadata.error", err.Error()))
-		return nil, fmt.Errorf("failed to get metadata: %v", err)
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
 	}
 
 	return res, nil
@@ -3,10 +3,18 @@ package main_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 var spellJson = []byte(`{
@@ -27,11 +35,16 @@ func TestSpell_Unmarshal(t *testing.T) {
 		t.Fatalf("Unmarshal() err = %v; want nil", err)
 	}
 
-	wantName := "fireball"
+	wantName := "Fireball"
 	if got.Name != wantName {
 		t.Errorf("Spell Name got %v; want %v", got.Name, wantName)
 	}
 
+	wantKey := "fireball"
+	if got.Key != wantKey {
+		t.Errorf("Spell Key got %v; want %v", got.Key, wantKey)
+	}
+
 	wantDescription := "Does the Big Boom"
 	if got.Description != wantDescription {
 		t.Errorf("Spell Description got %v; want %v", got.Description, wantDescription)
@@ -40,7 +53,7 @@ func TestSpell_Unmarshal(t *testing.T) {
 
 func TestSpell_Marshal(t *testing.T) {
 	want := spellapi.Spell{
-		Name:        "fireball",
+		Name:        "Melf's Acid Arrow",
 		Description: "Does the Big Boom",
 	}
 
@@ -49,8 +62,8 @@ func TestSpell_Marshal(t *testing.T) {
 		t.Fatalf("Marshal() err = %v; want nil", err)
 	}
 
-	if !strings.Contains(string(data), "Fireball") {
-		t.Errorf("Marshal() returned name %v; Want Fireball", string(data))
+	if !strings.Contains(string(data), `"Melf's Acid Arrow"`) {
+		t.Errorf("Marshal() returned name %v; Want Melf's Acid Arrow", string(data))
 	}
 
 	var got spellapi.Spell
@@ -64,6 +77,110 @@ func TestSpell_Marshal(t *testing.T) {
 	}
 }
 
+func TestFindSpell_DisplayName(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"AoE Ward","description":"Blocks areas","metadata":{"system":"test1"}}`,
+		`{"name":"Bigby's Hand of Doom","description":"Big hand","metadata":{"system":"test1"}}`,
+		`{"name":"Melf's Acid Arrow","description":"Acid","metadata":{"system":"test1"}}`,
+		`{"name":"Éclair  de Feu","description":"Lightning","metadata":{"system":"test1"}}`,
+		// Only the vowel sign, a nonspacing mark, tells these apart
+		`{"name":"कुल","description":"Clan","metadata":{"system":"test1"}}`,
+		`{"name":"कल","description":"Tomorrow","metadata":{"system":"test1"}}`,
+	)
+
+	testCases := []struct {
+		lookup string
+		want   string
+	}{
+		{"aoe ward", "AoE Ward"},
+		{"BIGBY'S  HAND OF DOOM", "Bigby's Hand of Doom"},
+		{"melf's acid arrow", "Melf's Acid Arrow"},
+		{"eclair de feu", "Éclair  de Feu"},
+		{"ÉCLAIR DE FEU", "Éclair  de Feu"},
+		{"कुल", "कुल"},
+		{"कल", "कल"},
+	}
+
+	for _, v := range testCases {
+		got, err := spellapi.FindSpell(ctx, store, v.lookup, url.Values{})
+		if err != nil || got.Name != v.want {
+			t.Errorf("FindSpell(%s) = %q, %v; want %q", v.lookup, got.Name, err, v.want)
+		}
+	}
+
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"eclair de feu","description":"Again","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	if _, err := spellapi.AddSpell(ctx, store, spell, "tester"); !errors.Is(err, spellapi.ErrConflict) {
+		t.Errorf("AddSpell(eclair de feu) err = %v; want %v", err, spellapi.ErrConflict)
+	}
+
+	spell, err = spellapi.ParseSpell(ctx, store, []byte(`{"name":"MELF'S ACID ARROW","description":"Again","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	if _, err := spellapi.AddSpell(ctx, store, spell, "tester"); !errors.Is(err, spellapi.ErrConflict) {
+		t.Errorf("AddSpell(MELF'S ACID ARROW) err = %v; want %v", err, spellapi.ErrConflict)
+	}
+}
+
+func TestSpellAliases(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"Fireball","aliases":["Boule de Feu","Flame Orb"],"description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"Flame Orb","description":"Rolling fire","metadata":{"system":"test2"}}`,
+	)
+
+	got, err := spellapi.FindSpell(ctx, store, "boule de feu", url.Values{})
+	if err != nil || got.Name != "Fireball" || got.MatchedAlias != "Boule de Feu" {
+		t.Errorf("FindSpell(boule de feu) = %q via %q, %v; want Fireball via Boule de Feu", got.Name, got.MatchedAlias, err)
+	}
+	if !strings.Contains(got.String(), `"matchedAlias":"Boule de Feu"`) {
+		t.Errorf("Marshal() returned %s; want the alias it was found by", got)
+	}
+
+	// A spell's own name wins over another spell's alias
+	got, err = spellapi.FindSpell(ctx, store, "flame orb", url.Values{})
+	if err != nil || got.Metadata.System != "test2" || got.MatchedAlias != "" {
+		t.Errorf("FindSpell(flame orb) = %v, %v; want the test2 spell by name", got, err)
+	}
+
+	testCases := []struct {
+		spell  string
+		result error
+	}{
+		{`{"name":"Flame Orb","description":"Clash","metadata":{"system":"test1"}}`, spellapi.ErrConflict},
+		{`{"name":"Fire Sphere","aliases":["FIREBALL"],"description":"Clash","metadata":{"system":"test1"}}`, spellapi.ErrConflict},
+		{`{"name":"Fire Sphere","aliases":["boule de feu"],"description":"Clash","metadata":{"system":"test1"}}`, spellapi.ErrConflict},
+		{`{"name":"Fire Sphere","aliases":["Orb","orb"],"description":"Twice","metadata":{"system":"test1"}}`, spellapi.ErrValidation},
+		{`{"name":"Fire Sphere","aliases":["Boule de Feu"],"description":"Fine","metadata":{"system":"test3"}}`, nil},
+	}
+
+	for _, v := range testCases {
+		spell, err := spellapi.ParseSpell(ctx, store, []byte(v.spell))
+		if err != nil {
+			t.Fatalf("ParseSpell() err = %v; want nil", err)
+		}
+
+		_, err = spellapi.AddSpell(ctx, store, spell, "tester")
+		if v.result == nil && err != nil {
+			t.Errorf("AddSpell(%s) err = %v; want nil", v.spell, err)
+		} else if v.result != nil && !errors.Is(err, v.result) {
+			t.Errorf("AddSpell(%s) err = %v; want %v", v.spell, err, v.result)
+		}
+	}
+
+	// Keeping its own aliases isn't a collision
+	_, err = spellapi.PatchSpell(ctx, store, "flame orb", url.Values{"system": []string{"test1"}}, []byte(`{"description":"Bigger boom"}`), spellapi.MergePatchContentType, "", "tester")
+	if err != nil {
+		t.Errorf("PatchSpell() err = %v; want nil", err)
+	}
+}
+
 func TestSpellMetadata_Marshal(t *testing.T) {
 	want := spellapi.SpellMetadata{
 		Creator: "TestUser",
@@ -81,8 +198,12 @@ func TestSpellMetadata_Marshal(t *testing.T) {
 		t.Fatalf("Marshal() err = %v; want nil", err)
 	}
 
-	if got.Creator != "" {
-		t.Errorf("Marshal() returned creator %v; Want nil", got.Creator)
+	if got.Creator != want.Creator {
+		t.Errorf("Marshal() returned creator %v; Want %v", got.Creator, want.Creator)
+	}
+
+	if strings.Contains(string(data), "createdAt") || strings.Contains(string(data), "updatedAt") {
+		t.Errorf("Marshal() returned %s; want no timestamps when they aren't set", data)
 	}
 
 	if got.System != want.System {
@@ -116,14 +237,681 @@ func TestParseSpell(t *testing.T) {
 		},
 	}
 
+	store := db.NewMemoryDB()
 	for _, v := range testCases {
 
 		ctx := context.Background()
-		_, err := spellapi.ParseSpell(ctx, []byte(v.input))
+		_, err := spellapi.ParseSpell(ctx, store, []byte(v.input))
+		if !v.hasError && err != nil {
+			t.Errorf("ParseSpell(%s) err = %v; want nil", v.input, err)
+		} else if v.hasError && err == nil {
+			t.Errorf("ParseSpell(%s) err = nil; want %v", v.input, v.result)
+		}
 		if v.hasError && err != nil {
 			if err.Error() != v.result {
 				t.Errorf("ParseSpell() err %v, want %v", err, v.result)
 			}
+			if !errors.Is(err, spellapi.ErrValidation) {
+				t.Errorf("ParseSpell() err %v is not a validation error", err)
+			}
+		}
+	}
+}
+
+func addTestSpells(t *testing.T, store spellapi.Store, spells ...string) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, v := range spells {
+		spell, err := spellapi.ParseSpell(ctx, store, []byte(v))
+		if err != nil {
+			t.Fatalf("ParseSpell() err = %v; want nil", err)
+		}
+		if _, err := spellapi.AddSpell(ctx, store, spell, "tester"); err != nil {
+			t.Fatalf("AddSpell() err = %v; want nil", err)
+		}
+	}
+}
+
+func TestReplaceSpell(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"fireball","description":"Bigger boom","metadata":{"system":"test2"}}`,
+		`{"name":"cure wounds","description":"Heals","metadata":{"system":"test1"}}`,
+	)
+
+	testCases := []struct {
+		name   string
+		system string
+		input  string
+		result error
+	}{
+		{"fireball", "", `{"name":"fireball","description":"x","metadata":{"system":"test1"}}`, spellapi.ErrAmbiguousMatch},
+		{"magic missile", "test1", `{"name":"magic missile","description":"x","metadata":{"system":"test1"}}`, spellapi.ErrNotFound},
+		{"cure wounds", "test1", `{"name":"fireball","description":"x","metadata":{"system":"test1"}}`, spellapi.ErrConflict},
+		{"fireball", "test1", `{"name":"fireball","description":"Huge boom","spelldata":{"level":3},"metadata":{"system":"test1"}}`, nil},
+		{"cure wounds", "test1", `{"name":"cure light wounds","description":"Heals a bit","metadata":{"system":"test1"}}`, nil},
+	}
+
+	for _, v := range testCases {
+		spell, err := spellapi.ParseSpell(ctx, store, []byte(v.input))
+		if err != nil {
+			t.Fatalf("ParseSpell() err = %v; want nil", err)
+		}
+
+		query := url.Values{}
+		if v.system != "" {
+			query.Set("system", v.system)
+		}
+
+		_, err = spellapi.ReplaceSpell(ctx, store, v.name, query, spell, "", "tester")
+		if v.result == nil && err != nil {
+			t.Errorf("ReplaceSpell(%s) err = %v; want nil", v.name, err)
+		} else if v.result != nil && !errors.Is(err, v.result) {
+			t.Errorf("ReplaceSpell(%s) err = %v; want %v", v.name, err, v.result)
+		}
+	}
+
+	got, err := spellapi.FindSpell(ctx, store, "fireball", url.Values{"system": []string{"test1"}})
+	if err != nil {
+		t.Fatalf("FindSpell() err = %v; want nil", err)
+	}
+	if got.Description != "Huge boom" {
+		t.Errorf("ReplaceSpell() description %v; want Huge boom", got.Description)
+	}
+
+	got, err = spellapi.FindSpell(ctx, store, "cure light wounds", url.Values{"system": []string{"test1"}})
+	if err != nil {
+		t.Fatalf("FindSpell() err = %v; want nil", err)
+	}
+	if got.Description != "Heals a bit" {
+		t.Errorf("ReplaceSpell() did not rename spell, got %v", got)
+	}
+}
+
+func TestDeleteSpell(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"fireball","description":"Bigger boom","metadata":{"system":"test2"}}`,
+	)
+
+	testCases := []struct {
+		name   string
+		system string
+		dryRun bool
+		result error
+	}{
+		{"fireball", "", false, spellapi.ErrAmbiguousMatch},
+		{"magic missile", "test1", false, spellapi.ErrNotFound},
+		{"fireball", "test1", true, nil},
+		{"fireball", "test1", false, nil},
+		{"fireball", "test1", false, spellapi.ErrNotFound},
+	}
+
+	for _, v := range testCases {
+		query := url.Values{}
+		if v.system != "" {
+			query.Set("system", v.system)
+		}
+
+		got, err := spellapi.DeleteSpell(ctx, store, v.name, query, "", "tester", v.dryRun)
+		if v.result != nil {
+			if !errors.Is(err, v.result) {
+				t.Errorf("DeleteSpell(%s, %s) err = %v; want %v", v.name, v.system, err, v.result)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("DeleteSpell(%s, %s) err = %v; want nil", v.name, v.system, err)
+		}
+
+		if got.Description != "Big boom" {
+			t.Errorf("DeleteSpell(%s, %s) returned %v", v.name, v.system, got)
+		}
+	}
+
+	remaining, err := spellapi.GetAllSpell(ctx, store, url.Values{})
+	if err != nil {
+		t.Fatalf("GetAllSpell() err = %v; want nil", err)
+	}
+	if len(remaining) != 1 || remaining[0].Metadata.System != "test2" {
+		t.Errorf("DeleteSpell() left %v; want only the test2 fireball", remaining)
+	}
+}
+
+func TestAmbiguousMatchStatus(t *testing.T) {
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"fireball","description":"Bigger boom","metadata":{"system":"test2"}}`,
+	)
+	service := spellapi.NewSpellService(store, testFlags(true), 0)
+
+	testCases := []struct {
+		method  string
+		target  string
+		handler func(http.ResponseWriter, *http.Request)
+		want    int
+	}{
+		// Looking up a name that matches more than one spell is a bad
+		// request, deleting one conflicts
+		{"GET", "/spells/fireball", service.GetSpellHandler, http.StatusBadRequest},
+		{"DELETE", "/spells/fireball?dryRun=true", service.DeleteSpellHandler, http.StatusConflict},
+	}
+
+	for _, v := range testCases {
+		r := httptest.NewRequest(v.method, v.target, nil)
+		r = mux.SetURLVars(r, map[string]string{"name": "fireball"})
+		w := httptest.NewRecorder()
+		v.handler(w, r)
+
+		var problem spellapi.Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("Unmarshal() err = %v; want nil", err)
+		}
+		if w.Code != v.want || problem.Status != v.want || problem.Type != spellapi.AmbiguousProblem {
+			t.Errorf("%s %s = %d, %+v; want %d %s", v.method, v.target, w.Code, problem, v.want, spellapi.AmbiguousProblem)
+		}
+	}
+}
+
+func TestSpellById(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	added, err := spellapi.AddSpell(ctx, store, spell, "tester")
+	if err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+	id := added.ID.Hex()
+
+	data, err := json.Marshal(added)
+	if err != nil || !strings.Contains(string(data), `"id":"`+id+`"`) {
+		t.Errorf("Marshal() = %s, %v; want the id included", data, err)
+	}
+
+	renamed, err := spellapi.ParseSpell(ctx, store, []byte(`{"id":"000000000000000000000000","name":"fire blast","description":"Big boom","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	_, err = spellapi.ReplaceSpellById(ctx, store, id, renamed, "", "tester")
+	if err != nil {
+		t.Fatalf("ReplaceSpellById() err = %v; want nil", err)
+	}
+
+	patched, err := spellapi.PatchSpellById(ctx, store, id, []byte(`{"description":"Bigger boom"}`), spellapi.MergePatchContentType, "", "tester")
+	if err != nil {
+		t.Fatalf("PatchSpellById() err = %v; want nil", err)
+	}
+	if patched.ID != added.ID || patched.Name != "fire blast" || patched.Description != "Bigger boom" {
+		t.Errorf("PatchSpellById() returned %v; want the renamed spell with the same id", patched)
+	}
+
+	got, err := spellapi.FindSpellById(ctx, store, id)
+	if err != nil || got.Name != "fire blast" || got.ID != added.ID {
+		t.Errorf("FindSpellById() = %v, %v; want the renamed spell", got, err)
+	}
+
+	_, err = spellapi.DeleteSpellById(ctx, store, id, "", "tester", false)
+	if err != nil {
+		t.Fatalf("DeleteSpellById() err = %v; want nil", err)
+	}
+
+	_, err = spellapi.DeleteSpellById(ctx, store, id, "", "tester", false)
+	if !errors.Is(err, spellapi.ErrNotFound) {
+		t.Errorf("DeleteSpellById() err = %v; want %v", err, spellapi.ErrNotFound)
+	}
+
+	_, err = spellapi.FindSpellById(ctx, store, "not-an-id")
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("FindSpellById() err = %v; want %v", err, spellapi.ErrValidation)
+	}
+}
+
+func TestSpellByIdRoutes(t *testing.T) {
+	store := db.NewMemoryDB()
+	addTestSpells(t, store, `{"name":"id","description":"Names itself","metadata":{"system":"test1"}}`)
+	added, err := spellapi.FindSpell(context.Background(), store, "id", url.Values{})
+	if err != nil {
+		t.Fatalf("FindSpell() err = %v; want nil", err)
+	}
+	router := spellapi.NewRouter(spellapi.NewSpellService(store, testFlags(true), 0))
+
+	// A spell called id is still reached by name, the by-ID routes only
+	// match an ObjectID
+	testCases := []struct {
+		target string
+		want   int
+	}{
+		{"/spells/id", http.StatusOK},
+		{"/spells/id/revisions", http.StatusOK},
+		{"/spells/id/" + added.ID.Hex(), http.StatusOK},
+		{"/spells/id/" + strings.Repeat("0", 24), http.StatusNotFound},
+		{"/spells/id/not-an-id", http.StatusNotFound},
+	}
+
+	for _, v := range testCases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", v.target, nil))
+		if w.Code != v.want {
+			t.Errorf("GET %s = %d; want %d", v.target, w.Code, v.want)
+		}
+	}
+}
+
+func TestSpellIfMatch(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store, `{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`)
+
+	query := url.Values{"system": []string{"test1"}}
+	original, err := spellapi.FindSpell(ctx, store, "fireball", query)
+	if err != nil {
+		t.Fatalf("FindSpell() err = %v; want nil", err)
+	}
+
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"fireball","description":"Bigger boom","metadata":{"system":"test1"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+
+	updated, err := spellapi.ReplaceSpell(ctx, store, "fireball", query, spell, original.ETag(), "tester")
+	if err != nil {
+		t.Fatalf("ReplaceSpell() err = %v; want nil", err)
+	}
+	if updated.ETag() == original.ETag() {
+		t.Errorf("ReplaceSpell() kept ETag %s; want a new one", updated.ETag())
+	}
+
+	// The first edit has moved the spell on so the original ETag is stale
+	_, err = spellapi.ReplaceSpell(ctx, store, "fireball", query, spell, original.ETag(), "tester")
+	if !errors.Is(err, spellapi.ErrPreconditionFailed) {
+		t.Errorf("ReplaceSpell() err = %v; want %v", err, spellapi.ErrPreconditionFailed)
+	}
+
+	_, err = spellapi.PatchSpell(ctx, store, "fireball", query, []byte(`{"description":"Huge boom"}`), spellapi.MergePatchContentType, original.ETag(), "tester")
+	if !errors.Is(err, spellapi.ErrPreconditionFailed) {
+		t.Errorf("PatchSpell() err = %v; want %v", err, spellapi.ErrPreconditionFailed)
+	}
+
+	_, err = spellapi.DeleteSpell(ctx, store, "fireball", query, original.ETag(), "tester", false)
+	if !errors.Is(err, spellapi.ErrPreconditionFailed) {
+		t.Errorf("DeleteSpell() err = %v; want %v", err, spellapi.ErrPreconditionFailed)
+	}
+
+	_, err = spellapi.PatchSpell(ctx, store, "fireball", query, []byte(`{"description":"Huge boom"}`), spellapi.MergePatchContentType, "*", "tester")
+	if err != nil {
+		t.Errorf("PatchSpell(*) err = %v; want nil", err)
+	}
+
+	got, err := spellapi.FindSpell(ctx, store, "fireball", query)
+	if err != nil || got.Version != 3 || got.Description != "Huge boom" {
+		t.Errorf("FindSpell() = %+v, %v; want version 3", got, err)
+	}
+}
+
+func TestGetSpellPage(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","spelldata":{"school":"evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"cure wounds","description":"Heals","spelldata":{"school":"evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"shield","description":"Blocks","spelldata":{"school":"abjuration"},"metadata":{"system":"test1"}}`,
+		`{"name":"light","description":"Glows","spelldata":{"school":"evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"fireball","description":"Bigger boom","spelldata":{"school":"evocation"},"metadata":{"system":"test2"}}`,
+	)
+
+	query := url.Values{"school": []string{"evocation"}}
+	var names []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("GetSpellPage() returned too many pages")
+		}
+
+		page, err := spellapi.GetSpellPage(ctx, store, query, 2, cursor, true)
+		if err != nil {
+			t.Fatalf("GetSpellPage() err = %v; want nil", err)
+		}
+		if page.Total == nil || *page.Total != 4 {
+			t.Errorf("GetSpellPage() total = %v; want 4", page.Total)
+		}
+
+		for _, s := range page.Data {
+			names = append(names, s.Name+"/"+s.Metadata.System)
+		}
+
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	want := "cure wounds/test1,fireball/test1,fireball/test2,light/test1"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("GetSpellPage() returned %v; want %v", got, want)
+	}
+
+	_, err := spellapi.GetSpellPage(ctx, store, query, 2, "not-a-cursor", false)
+	if err == nil || err.Error() != spellapi.InvalidCursor {
+		t.Errorf("GetSpellPage() err = %v; want %v", err, spellapi.InvalidCursor)
+	}
+}
+
+func TestGetAllSpell_TypedValues(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","spelldata":{"level":3,"ritual":false},"metadata":{"system":"test1"}}`,
+		`{"name":"alarm","description":"Rings","spelldata":{"level":"1","ritual":true},"metadata":{"system":"test1"}}`,
+		`{"name":"detect magic","description":"Sees","spelldata":{"level":1,"ritual":"true"},"metadata":{"system":"test1"}}`,
+	)
+
+	testCases := []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"level": []string{"3"}}, 1},
+		{url.Values{"level": []string{"1"}}, 2},
+		{url.Values{"level": []string{"1.0"}}, 1},
+		{url.Values{"level": []string{"1", "3"}}, 3},
+		{url.Values{"ritual": []string{"true"}}, 2},
+		{url.Values{"ritual": []string{"False"}}, 1},
+	}
+
+	for _, v := range testCases {
+		got, err := spellapi.GetAllSpell(ctx, store, v.query)
+		if err != nil {
+			t.Fatalf("GetAllSpell(%v) err = %v; want nil", v.query, err)
+		}
+		if len(got) != v.want {
+			t.Errorf("GetAllSpell(%v) returned %d spells; want %d", v.query.Encode(), len(got), v.want)
+		}
+	}
+}
+
+func TestGetAllSpell_Operators(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","spelldata":{"level":3,"school":"evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"fire bolt","description":"Small boom","spelldata":{"level":0,"school":"Evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"animate dead","description":"Rises","spelldata":{"level":3,"school":"necromancy"},"metadata":{"system":"test1"}}`,
+		`{"name":"meteor swarm","description":"Biggest boom","spelldata":{"level":9,"school":"evocation","ritual":false},"metadata":{"system":"test1"}}`,
+	)
+
+	testCases := []struct {
+		query  string
+		want   int
+		result error
+	}{
+		{"level[gte]=3", 3, nil},
+		{"level[gte]=3&level[lt]=5", 2, nil},
+		{"level[gt]=3&school=evocation", 1, nil},
+		{"school[ne]=necromancy", 3, nil},
+		{"name[prefix]=Fire", 2, nil},
+		{"school[prefix]=evo", 3, nil},
+		{"ritual[exists]=true", 1, nil},
+		{"level[between]=3", 0, spellapi.ErrValidation},
+		{"level[gte=3", 0, spellapi.ErrValidation},
+		{"[gte]=3", 0, spellapi.ErrValidation},
+		{"level[gte]=3&level[gte]=4", 0, spellapi.ErrValidation},
+		{"ritual[exists]=maybe", 0, spellapi.ErrValidation},
+		{"level[gte]=high", 0, spellapi.ErrValidation},
+		{"level=true", 0, spellapi.ErrValidation},
+		{"school=3", 0, nil},
+		{"school[gte]=f", 1, nil},
+	}
+
+	for _, v := range testCases {
+		query, err := url.ParseQuery(v.query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%v) err = %v; want nil", v.query, err)
+		}
+
+		got, err := spellapi.GetAllSpell(ctx, store, query)
+		if v.result != nil {
+			if !errors.Is(err, v.result) {
+				t.Errorf("GetAllSpell(%v) err = %v; want %v", v.query, err, v.result)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("GetAllSpell(%v) err = %v; want nil", v.query, err)
+		}
+
+		if len(got) != v.want {
+			t.Errorf("GetAllSpell(%v) returned %d spells; want %d", v.query, len(got), v.want)
+		}
+	}
+}
+
+func TestGetAllSpell_SchemaTypes(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSystem(t, store, testSystem)
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","spelldata":{"level":3,"school":"evocation","components":["V","S","M"]},"metadata":{"system":"D&D 5e"}}`,
+		`{"name":"shield","description":"Blocks","spelldata":{"level":1,"school":"abjuration","components":["V","S"]},"metadata":{"system":"D&D 5e"}}`,
+	)
+
+	testCases := []struct {
+		query  url.Values
+		want   int
+		result error
+	}{
+		{url.Values{"system": []string{"D&D 5e"}, "level": []string{"3"}}, 1, nil},
+		{url.Values{"system": []string{"D&D 5e"}, "level[lte]": []string{"3"}}, 2, nil},
+		{url.Values{"system": []string{"D&D 5e"}, "level": []string{"third"}}, 0, spellapi.ErrValidation},
+		{url.Values{"system": []string{"D&D 5e"}, "components": []string{"M"}}, 1, nil},
+		{url.Values{"system": []string{"D&D 5e"}, "school": []string{"abjuration"}}, 1, nil},
+		{url.Values{"system": []string{"D&D 5e"}, "school": []string{"false"}}, 0, nil},
+		// Keys the schema doesn't describe use the saved values
+		{url.Values{"system": []string{"D&D 5e"}, "range": []string{"60"}}, 0, nil},
+	}
+
+	for _, v := range testCases {
+		got, err := spellapi.GetAllSpell(ctx, store, v.query)
+		if v.result != nil {
+			if !errors.Is(err, v.result) {
+				t.Errorf("GetAllSpell(%v) err = %v; want %v", v.query.Encode(), err, v.result)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("GetAllSpell(%v) err = %v; want nil", v.query.Encode(), err)
+		}
+
+		if len(got) != v.want {
+			t.Errorf("GetAllSpell(%v) returned %d spells; want %d", v.query.Encode(), len(got), v.want)
+		}
+	}
+}
+
+func TestGetAllSpell_CreatorAndUpdatedSince(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	start := time.Now().UTC().Add(-time.Minute)
+
+	for _, v := range []struct{ user, spell string }{
+		{"alice", `{"name":"fireball","description":"Big boom","metadata":{"system":"test1","creator":"mallory"}}`},
+		{"bob", `{"name":"cure wounds","description":"Heals","metadata":{"system":"test1"}}`},
+	} {
+		spell, err := spellapi.ParseSpell(ctx, store, []byte(v.spell))
+		if err != nil {
+			t.Fatalf("ParseSpell() err = %v; want nil", err)
+		}
+		if _, err := spellapi.AddSpell(ctx, store, spell, v.user); err != nil {
+			t.Fatalf("AddSpell() err = %v; want nil", err)
+		}
+	}
+
+	testCases := []struct {
+		query url.Values
+		want  int
+	}{
+		{url.Values{"creator": []string{"alice"}}, 1},
+		{url.Values{"creator": []string{"mallory"}}, 0},
+		{url.Values{"updatedSince": []string{start.Format(time.RFC3339)}}, 2},
+		{url.Values{"updatedSince": []string{start.Add(time.Hour).Format(time.RFC3339)}}, 0},
+		{url.Values{"updatedSince": []string{start.Format("2006-01-02")}, "creator": []string{"bob"}}, 1},
+	}
+
+	for _, v := range testCases {
+		got, err := spellapi.GetAllSpell(ctx, store, v.query)
+		if err != nil {
+			t.Fatalf("GetAllSpell(%s) err = %v; want nil", v.query.Encode(), err)
+		}
+		if len(got) != v.want {
+			t.Errorf("GetAllSpell(%s) returned %d spells; want %d", v.query.Encode(), len(got), v.want)
+		}
+	}
+
+	got, err := spellapi.FindSpell(ctx, store, "fireball", url.Values{})
+	if err != nil || got.Metadata.Creator != "alice" || got.Metadata.CreatedAt.Before(start) || !got.Metadata.UpdatedAt.Equal(got.Metadata.CreatedAt) {
+		t.Errorf("FindSpell() = %+v, %v; want it created by alice just now", got.Metadata, err)
+	}
+
+	_, err = spellapi.GetAllSpell(ctx, store, url.Values{"updatedSince": []string{"yesterday"}})
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("GetAllSpell(updatedSince=yesterday) err = %v; want %v", err, spellapi.ErrValidation)
+	}
+}
+
+func TestSearchSpells(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"A ball of flame explodes","spelldata":{"school":"evocation"},"metadata":{"system":"test1"}}`,
+		`{"name":"produce flame","description":"A flame appears in your hand","metadata":{"system":"test1"}}`,
+		`{"name":"wall of fire","description":"Creates a wall","spelldata":{"damage":"fire and flame"},"metadata":{"system":"test1"}}`,
+		`{"name":"flame strike","description":"Fire from above","metadata":{"system":"test2"}}`,
+		`{"name":"cure wounds","description":"Heals","metadata":{"system":"test1"}}`,
+	)
+
+	got, err := spellapi.SearchSpells(ctx, store, "flame", url.Values{"system": []string{"test1"}}, 10)
+	if err != nil {
+		t.Fatalf("SearchSpells() err = %v; want nil", err)
+	}
+
+	var names []string
+	for _, r := range got {
+		names = append(names, r.Spell.Name)
+	}
+	if want := "produce flame,fireball,wall of fire"; strings.Join(names, ",") != want {
+		t.Errorf("SearchSpells() returned %v; want %v", strings.Join(names, ","), want)
+	}
+
+	// Only the name, description and spelldata are searched, not metadata
+	// such as who created the spell
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"shield","description":"Blocks","metadata":{"system":"pyromancy"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	if _, err := spellapi.AddSpell(ctx, store, spell, "flamewarden"); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+	for _, text := range []string{"flamewarden", "pyromancy"} {
+		got, err := spellapi.SearchSpells(ctx, store, text, url.Values{}, 10)
+		if err != nil || len(got) != 0 {
+			t.Errorf("SearchSpells(%s) = %d results, %v; want none", text, len(got), err)
+		}
+	}
+
+	_, err = spellapi.SearchSpells(ctx, store, " ", url.Values{}, 10)
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("SearchSpells() err = %v; want %v", err, spellapi.ErrValidation)
+	}
+}
+
+func TestSuggestSpells(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"Fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"Fire Bolt","description":"Small boom","metadata":{"system":"test1"}}`,
+		`{"name":"Cure Wounds","description":"Heals","metadata":{"system":"test1"}}`,
+		`{"name":"Firebolt","description":"Other boom","metadata":{"system":"test2"}}`,
+	)
+
+	testCases := []struct {
+		name   string
+		system string
+		want   string
+	}{
+		{"firebal", "test1", "Fireball"},
+		{"Cure Wound", "test1", "Cure Wounds"},
+		{"fire", "test1", "Fireball,Fire Bolt"},
+		{"firebolt", "", "Firebolt,Fire Bolt,Fireball"},
+		{"magic missile", "test1", ""},
+	}
+
+	for _, v := range testCases {
+		query := url.Values{}
+		if v.system != "" {
+			query.Set("system", v.system)
+		}
+
+		got, err := spellapi.SuggestSpells(ctx, store, v.name, query, 5)
+		if err != nil {
+			t.Fatalf("SuggestSpells(%v) err = %v; want nil", v.name, err)
+		}
+
+		var names []string
+		for _, s := range got {
+			names = append(names, s.Name)
+		}
+		if strings.Join(names, ",") != v.want {
+			t.Errorf("SuggestSpells(%v) = %v; want %v", v.name, strings.Join(names, ","), v.want)
+		}
+	}
+}
+
+func TestAutocompleteSpells(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"Fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"Fire Bolt","description":"Small boom","metadata":{"system":"test1"}}`,
+		`{"name":"Cure Wounds","description":"Heals","metadata":{"system":"test1"}}`,
+		`{"name":"Fire Shield","description":"Warm","metadata":{"system":"test2"}}`,
+	)
+
+	// Saved before names were limited to letters, numbers and spaces
+	raw, _ := bson.Marshal(bson.M{"name": "fire.bolt", "displayName": "Fire.Bolt", "description": "Dotted boom", "metadata": bson.M{"system": "test1"}})
+	if err := store.AddSpell(ctx, raw); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+
+	testCases := []struct {
+		prefix string
+		system string
+		limit  int64
+		want   string
+	}{
+		{"Fir", "test1", 25, "Fire Bolt/test1,Fire.Bolt/test1,Fireball/test1"},
+		{"fir", "", 2, "Fire Bolt/test1,Fire Shield/test2"},
+		{"fire.", "", 25, "Fire.Bolt/test1"},
+		{"x", "", 25, ""},
+	}
+
+	for _, v := range testCases {
+		got, err := spellapi.AutocompleteSpells(ctx, store, v.prefix, v.system, v.limit)
+		if err != nil {
+			t.Fatalf("AutocompleteSpells(%v) err = %v; want nil", v.prefix, err)
+		}
+
+		var names []string
+		for _, s := range got {
+			names = append(names, s.Name+"/"+s.System)
+		}
+		if strings.Join(names, ",") != v.want {
+			t.Errorf("AutocompleteSpells(%v) = %v; want %v", v.prefix, strings.Join(names, ","), v.want)
 		}
 	}
 }
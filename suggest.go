@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SpellSuggestion is a spell with a name close to one that was asked for.
+// Distance is the number of single character edits between the two names.
+type SpellSuggestion struct {
+	Name     string `json:"name"`
+	System   string `json:"system"`
+	Distance int    `json:"distance"`
+}
+
+// SuggestSpells finds up to limit spells with names similar to name, closest
+// first. The query narrows down which spells are considered the same as
+// FindSpell, usually to a single system.
+func SuggestSpells(ctx context.Context, db Store, name string, query url.Values, limit int) ([]SpellSuggestion, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "SuggestSpells")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("SuggestSpells.SpellName", name),
+		attribute.String("SuggestSpells.RawQuery", query.Encode()),
+		attribute.Int("SuggestSpells.Limit", limit),
+	)
+
+	bsonQuery := bson.M{}
+
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("SuggestSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	results, err := db.GetSpellNames(ctx, bsonQuery, 0)
+	if err != nil {
+		span.SetAttributes(attribute.String("SuggestSpells.Error", err.Error()))
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	spells, err := decodeSpells(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("SuggestSpells.Error", err.Error()))
+		return nil, err
+	}
+
+	name = normalizeName(name)
+	suggestions := []SpellSuggestion{}
+	for _, spell := range spells {
+		distance := editDistance(name, spell.Key)
+
+		// Allow roughly one typo for every three characters, or any name that
+		// starts with what was typed
+		if distance <= maxSuggestionDistance(name) || strings.HasPrefix(spell.Key, name) {
+			suggestions = append(suggestions, SpellSuggestion{
+				Name:     spell.Name,
+				System:   spell.Metadata.System,
+				Distance: distance,
+			})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Distance < suggestions[j].Distance
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	span.SetAttributes(attribute.Int("SuggestSpells.ResultsCount", len(suggestions)))
+
+	return suggestions, nil
+}
+
+func maxSuggestionDistance(name string) int {
+	max := len([]rune(name)) / 3
+	if max < 2 {
+		return 2
+	}
+	return max
+}
+
+// editDistance is the Levenshtein distance between two strings, counted in
+// runes rather than bytes.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(br)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// System is a registered game system. Name is the canonical name spells are
+// saved with, a spell sent with one of the Aliases as its system is saved
+// under Name instead. Key and AliasKeys are normalized the same way as spell
+// names. Schema is an optional JSON Schema that the spelldata of every spell
+// in the system has to match.
+type System struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Key       string             `json:"-" bson:"key"`
+	Name      string             `json:"name" bson:"name"`
+	Aliases   []string           `json:"aliases,omitempty" bson:"aliases,omitempty"`
+	AliasKeys []string           `json:"-" bson:"aliasKeys,omitempty"`
+	Schema    json.RawMessage    `json:"schema,omitempty" bson:"schema,omitempty"`
+}
+
+func (s System) String() string {
+	json, _ := json.Marshal(s)
+
+	return string(json)
+}
+
+// setKeys trims the name and aliases and works out the keys they're looked up
+// by
+func (s *System) setKeys() {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Key = normalizeName(s.Name)
+
+	s.AliasKeys = nil
+	for i, alias := range s.Aliases {
+		s.Aliases[i] = strings.TrimSpace(alias)
+		s.AliasKeys = append(s.AliasKeys, normalizeName(alias))
+	}
+}
+
+// ParseSystem reads a system from a request body and checks its schema is one
+// that spells can be validated against
+func ParseSystem(ctx context.Context, in []byte) (System, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ParseSystem")
+	defer span.End()
+
+	var s System
+	err := json.Unmarshal(in, &s)
+	if err != nil {
+		span.SetAttributes(attribute.String("ParseSystem.Error", err.Error()))
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return System{}, newValidationError(typeErr.Field, "invalid value for field: %s", typeErr.Field)
+		}
+		return System{}, newValidationError("", "invalid system: %v", err)
+	}
+
+	s.setKeys()
+
+	if s.Key == "" {
+		span.SetAttributes(attribute.String("ParseSystem.MissingField", "Name"))
+		return s, newValidationError("name", "missing required field: name")
+	}
+
+	for _, aliasKey := range s.AliasKeys {
+		if aliasKey == "" {
+			span.SetAttributes(attribute.String("ParseSystem.Error", "EmptyAlias"))
+			return s, newValidationError("aliases", "aliases can't be empty")
+		}
+	}
+
+	if len(s.Schema) > 0 && string(s.Schema) != "null" {
+		if _, err := parseSchema("schema", s.Schema); err != nil {
+			span.SetAttributes(attribute.String("ParseSystem.Error", err.Error()))
+			return s, err
+		}
+	} else {
+		s.Schema = nil
+	}
+
+	return s, nil
+}
+
+// GetSystems returns every registered system in name order
+func GetSystems(ctx context.Context, db Store) ([]System, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetSystems")
+	defer span.End()
+
+	results, err := db.GetSystems(ctx, bson.M{})
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystems.Error", err.Error()))
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	systems, err := decodeSystems(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetSystems.Error", err.Error()))
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("GetSystems.ResultsCount", len(systems)))
+
+	return systems, nil
+}
+
+// FindSystem looks up a system by its name or any of its aliases, ignoring
+// case and accents the same as spell names. Returns ErrSystemNotFound if it
+// isn't registered.
+func FindSystem(ctx context.Context, db Store, name string) (System, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "FindSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("FindSystem.Name", name))
+
+	key := normalizeName(name)
+	results, err := db.GetSystems(ctx, bson.M{
+		"$or": []bson.M{
+			{"key": bson.M{"$eq": key}},
+			{"aliasKeys": bson.M{"$eq": key}},
+		},
+	})
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSystem.Error", err.Error()))
+		return System{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	systems, err := decodeSystems(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindSystem.Error", err.Error()))
+		return System{}, err
+	}
+
+	// Names and aliases are unique across systems so there's at most one
+	if len(systems) == 0 {
+		span.SetAttributes(attribute.String("FindSystem.Error", ErrSystemNotFound.Error()))
+		return System{}, fmt.Errorf("%w: %q", ErrSystemNotFound, name)
+	}
+
+	return systems[0], nil
+}
+
+// AddSystem registers a new system and returns it as stored
+func AddSystem(ctx context.Context, db Store, system System) (System, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "AddSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.Stringer("AddSystem.System", system))
+
+	system.setKeys()
+	system.ID = primitive.NewObjectID()
+
+	err := checkSystemConflicts(ctx, db, system)
+	if err != nil {
+		span.SetAttributes(attribute.String("AddSystem.Error", err.Error()))
+		return System{}, err
+	}
+
+	bsonSystem, err := bson.Marshal(system)
+	if err != nil {
+		span.SetAttributes(attribute.String("AddSystem.Error", err.Error()))
+		return System{}, fmt.Errorf("failed to marshall data: %w", err)
+	}
+
+	err = db.AddSystem(ctx, bsonSystem)
+	if errors.Is(err, ErrSystemConflict) {
+		span.SetAttributes(attribute.String("AddSystem.Error", err.Error()))
+		return System{}, err
+	} else if err != nil {
+		span.SetAttributes(attribute.String("AddSystem.Error", err.Error()))
+		return System{}, fmt.Errorf("failed to write system to DB: %w", err)
+	}
+
+	return system, nil
+}
+
+// ReplaceSystem overwrites the system called name, or with name as an alias.
+// Spells already saved under the old name keep it, add it as an alias so
+// new ones are saved under the new name.
+func ReplaceSystem(ctx context.Context, db Store, name string, system System) (System, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ReplaceSystem")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("ReplaceSystem.Name", name),
+		attribute.Stringer("ReplaceSystem.System", system),
+	)
+
+	existing, err := FindSystem(ctx, db, name)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceSystem.Error", err.Error()))
+		return System{}, err
+	}
+
+	system.setKeys()
+	system.ID = existing.ID
+
+	err = checkSystemConflicts(ctx, db, system)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceSystem.Error", err.Error()))
+		return System{}, err
+	}
+
+	bsonSystem, err := bson.Marshal(system)
+	if err != nil {
+		span.SetAttributes(attribute.String("ReplaceSystem.Error", err.Error()))
+		return System{}, fmt.Errorf("failed to marshall data: %w", err)
+	}
+
+	err = db.UpdateSystem(ctx, bson.M{"_id": existing.ID}, bsonSystem)
+	if errors.Is(err, ErrSystemNotFound) || errors.Is(err, ErrSystemConflict) {
+		span.SetAttributes(attribute.String("ReplaceSystem.Error", err.Error()))
+		return System{}, err
+	} else if err != nil {
+		span.SetAttributes(attribute.String("ReplaceSystem.Error", err.Error()))
+		return System{}, fmt.Errorf("failed to update system in DB: %w", err)
+	}
+
+	return system, nil
+}
+
+// DeleteSystem removes the system called name, or with name as an alias.
+// Its spells are kept but are no longer checked against its schema.
+func DeleteSystem(ctx context.Context, db Store, name string) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "DeleteSystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("DeleteSystem.Name", name))
+
+	existing, err := FindSystem(ctx, db, name)
+	if err != nil {
+		span.SetAttributes(attribute.String("DeleteSystem.Error", err.Error()))
+		return err
+	}
+
+	err = db.DeleteSystem(ctx, bson.M{"_id": existing.ID})
+	if errors.Is(err, ErrSystemNotFound) {
+		span.SetAttributes(attribute.String("DeleteSystem.Error", err.Error()))
+		return err
+	} else if err != nil {
+		span.SetAttributes(attribute.String("DeleteSystem.Error", err.Error()))
+		return fmt.Errorf("failed to delete system from DB: %w", err)
+	}
+
+	return nil
+}
+
+// checkSystemConflicts makes sure neither the name nor any of the aliases of a
+// system are used as the name or an alias of another system, so every name
+// resolves to exactly one system
+func checkSystemConflicts(ctx context.Context, db Store, system System) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "CheckSystemConflicts")
+	defer span.End()
+
+	keys := append([]string{system.Key}, system.AliasKeys...)
+	names := append([]string{system.Name}, system.Aliases...)
+
+	for i := range keys {
+		for j := 0; j < i; j++ {
+			if keys[i] == keys[j] {
+				span.SetAttributes(attribute.String("CheckSystemConflicts.Error", "DuplicateAlias"))
+				return newValidationError("aliases", "alias %q is the same as %q", names[i], names[j])
+			}
+		}
+	}
+
+	results, err := db.GetSystems(ctx, bson.M{
+		"$or": []bson.M{
+			{"key": bson.M{"$in": keys}},
+			{"aliasKeys": bson.M{"$in": keys}},
+		},
+	})
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckSystemConflicts.Error", err.Error()))
+		return fmt.Errorf("failed to check for existing systems: %w", err)
+	}
+
+	others, err := decodeSystems(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("CheckSystemConflicts.Error", err.Error()))
+		return err
+	}
+
+	for _, other := range others {
+		if other.ID == system.ID {
+			continue
+		}
+
+		span.SetAttributes(attribute.String("CheckSystemConflicts.Error", ErrSystemConflict.Error()))
+
+		used := append([]string{other.Key}, other.AliasKeys...)
+		for i, k := range keys {
+			for _, u := range used {
+				if k == u {
+					return fmt.Errorf("%w: %q is already used by %q", ErrSystemConflict, names[i], other.Name)
+				}
+			}
+		}
+
+		return ErrSystemConflict
+	}
+
+	return nil
+}
+
+// applySystem saves a spell under the canonical name of its system, if the
+// system is registered, and checks its spelldata against the system's schema.
+// Every field that doesn't match is returned together in ValidationErrors.
+func applySystem(ctx context.Context, db Store, spell *Spell) error {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "ApplySystem")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("ApplySystem.System", spell.Metadata.System))
+
+	system, err := FindSystem(ctx, db, spell.Metadata.System)
+	if errors.Is(err, ErrSystemNotFound) {
+		// Systems don't have to be registered
+		return nil
+	} else if err != nil {
+		span.SetAttributes(attribute.String("ApplySystem.Error", err.Error()))
+		return err
+	}
+
+	spell.Metadata.System = system.Name
+	span.SetAttributes(attribute.String("ApplySystem.Canonical", system.Name))
+
+	if len(system.Schema) == 0 {
+		return nil
+	}
+
+	schema, err := parseSchema("schema", system.Schema)
+	if err != nil {
+		span.SetAttributes(attribute.String("ApplySystem.Error", err.Error()))
+		return fmt.Errorf("system %q has an invalid schema: %v", system.Name, err)
+	}
+
+	// Round trip spelldata so it has the same types as the schema, whatever
+	// it was decoded from
+	var spellData interface{} = map[string]interface{}{}
+	if spell.SpellData != nil {
+		raw, err := json.Marshal(spell.SpellData)
+		if err != nil {
+			span.SetAttributes(attribute.String("ApplySystem.Error", err.Error()))
+			return fmt.Errorf("failed to marshall data: %w", err)
+		}
+		if err := json.Unmarshal(raw, &spellData); err != nil {
+			span.SetAttributes(attribute.String("ApplySystem.Error", err.Error()))
+			return fmt.Errorf("failed to unmarshall data: %w", err)
+		}
+	}
+
+	errs := validateSchema(schema, spellData, "spelldata")
+	if len(errs) > 0 {
+		span.SetAttributes(attribute.Int("ApplySystem.ValidationErrors", len(errs)))
+		return ValidationErrors(errs)
+	}
+
+	return nil
+}
+
+// systemSpellings returns every name a registered system's spells can be
+// saved under, its canonical name and its aliases, for the system name or
+// alias given. Systems that aren't registered only have the name given.
+// Spells saved before their system or alias was registered keep the name
+// they were saved with, so this finds them as well.
+func systemSpellings(ctx context.Context, db Store, name string) ([]string, error) {
+	system, err := FindSystem(ctx, db, name)
+	if errors.Is(err, ErrSystemNotFound) {
+		return []string{name}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return append([]string{system.Name}, system.Aliases...), nil
+}
+
+// systemNames maps the name and aliases of every registered system to its
+// canonical name, by key
+func systemNames(ctx context.Context, db Store) (map[string]string, error) {
+	systems, err := GetSystems(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, s := range systems {
+		names[s.Key] = s.Name
+		for _, k := range s.AliasKeys {
+			names[k] = s.Name
+		}
+	}
+
+	return names, nil
+}
+
+// canonicalSystems replaces any system names that are registered aliases with
+// the system's name, dropping the duplicates that leaves
+func canonicalSystems(values []string, names map[string]string) []string {
+	seen := map[string]bool{}
+	systems := []string{}
+	for _, v := range values {
+		if name, ok := names[normalizeName(v)]; ok {
+			v = name
+		}
+		if !seen[v] {
+			seen[v] = true
+			systems = append(systems, v)
+		}
+	}
+
+	sort.Strings(systems)
+
+	return systems
+}
+
+func decodeSystems(results []bson.M) ([]System, error) {
+	systems := []System{}
+	for _, v := range results {
+		temp, err := bson.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshall data: %w", err)
+		}
+
+		var s System
+		err = bson.Unmarshal(temp, &s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshall data: %w", err)
+		}
+		systems = append(systems, s)
+	}
+
+	return systems, nil
+}
@@ -0,0 +1,172 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+)
+
+const testSystem = `{
+	"name":"D&D 5e",
+	"aliases":["5e","dnd5e"],
+	"schema":{
+		"$schema":"https://json-schema.org/draft/2020-12/schema",
+		"type":"object",
+		"required":["level"],
+		"properties":{
+			"level":{"type":"integer","minimum":0,"maximum":9},
+			"school":{"enum":["evocation","abjuration"]},
+			"components":{"type":"array","items":{"type":"string","pattern":"^[VSM]$"}}
+		}
+	}
+}`
+
+func addTestSystem(t *testing.T, store spellapi.Store, in string) spellapi.System {
+	t.Helper()
+
+	ctx := context.Background()
+	system, err := spellapi.ParseSystem(ctx, []byte(in))
+	if err != nil {
+		t.Fatalf("ParseSystem() err = %v; want nil", err)
+	}
+
+	system, err = spellapi.AddSystem(ctx, store, system)
+	if err != nil {
+		t.Fatalf("AddSystem() err = %v; want nil", err)
+	}
+
+	return system
+}
+
+func TestParseSystem(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		input string
+		field string
+	}{
+		{`{"aliases":["5e"]}`, "name"},
+		{`{"name":"5e","aliases":[" "]}`, "aliases"},
+		{`{"name":"5e","schema":[]}`, "schema"},
+		{`{"name":"5e","schema":{"type":"object","$ref":"#/defs/level"}}`, "schema"},
+		{`{"name":"5e","schema":{"properties":{"level":{"type":"int"}}}}`, "schema.properties.level"},
+		{`{"name":"5e","schema":{"properties":{"school":{"pattern":"("}}}}`, "schema.properties.school"},
+	}
+
+	for _, v := range testCases {
+		_, err := spellapi.ParseSystem(ctx, []byte(v.input))
+
+		var validationErr *spellapi.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != v.field {
+			t.Errorf("ParseSystem(%s) err = %v; want a validation error on %s", v.input, err, v.field)
+		}
+	}
+
+	if _, err := spellapi.ParseSystem(ctx, []byte(testSystem)); err != nil {
+		t.Errorf("ParseSystem() err = %v; want nil", err)
+	}
+}
+
+func TestSystemRegistry(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+
+	// Saved before the system was registered
+	addTestSpells(t, store, `{"name":"Fireball","description":"Big boom","metadata":{"system":"5e"}}`)
+
+	addTestSystem(t, store, testSystem)
+
+	for _, in := range []string{`{"name":"DND5E"}`, `{"name":"Fifth","aliases":["5E"]}`} {
+		system, err := spellapi.ParseSystem(ctx, []byte(in))
+		if err != nil {
+			t.Fatalf("ParseSystem() err = %v; want nil", err)
+		}
+		_, err = spellapi.AddSystem(ctx, store, system)
+		if !errors.Is(err, spellapi.ErrSystemConflict) {
+			t.Errorf("AddSystem(%s) err = %v; want %v", in, err, spellapi.ErrSystemConflict)
+		}
+	}
+
+	spell, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"Shield","description":"Block","spelldata":{"level":1,"school":"abjuration","components":["V","S"]},"metadata":{"system":"dnd5e"}}`))
+	if err != nil {
+		t.Fatalf("ParseSpell() err = %v; want nil", err)
+	}
+	if spell.Metadata.System != "D&D 5e" {
+		t.Errorf("ParseSpell() system = %q; want the canonical D&D 5e", spell.Metadata.System)
+	}
+	if _, err := spellapi.AddSpell(ctx, store, spell, "tester"); err != nil {
+		t.Fatalf("AddSpell() err = %v; want nil", err)
+	}
+
+	// Every field that doesn't match is reported at once
+	_, err = spellapi.ParseSpell(ctx, store, []byte(`{"name":"Wish","description":"Anything","spelldata":{"level":10,"school":"illusion","components":["V","X"]},"metadata":{"system":"5e"}}`))
+	var validationErrs spellapi.ValidationErrors
+	if !errors.As(err, &validationErrs) || !errors.Is(err, spellapi.ErrValidation) {
+		t.Fatalf("ParseSpell() err = %v; want ValidationErrors", err)
+	}
+	fields := []string{}
+	for _, v := range validationErrs {
+		fields = append(fields, v.Field)
+	}
+	want := []string{"spelldata.components[1]", "spelldata.level", "spelldata.school"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("ParseSpell() errors on %v; want %v", fields, want)
+	}
+
+	_, err = spellapi.ParseSpell(ctx, store, []byte(`{"name":"Wish","description":"Anything","metadata":{"system":"D&D 5E"}}`))
+	if !errors.As(err, &validationErrs) || validationErrs[0].Field != "spelldata.level" {
+		t.Errorf("ParseSpell() without spelldata err = %v; want spelldata.level to be required", err)
+	}
+
+	// Unregistered systems aren't checked
+	if _, err := spellapi.ParseSpell(ctx, store, []byte(`{"name":"Wish","description":"Anything","spelldata":{"level":10},"metadata":{"system":"homebrew"}}`)); err != nil {
+		t.Errorf("ParseSpell() for an unregistered system err = %v; want nil", err)
+	}
+
+	systems, err := spellapi.GetSpellMetadata(ctx, store, "system")
+	if err != nil || !reflect.DeepEqual(systems, []string{"D&D 5e"}) {
+		t.Errorf("GetSpellMetadata(system) = %v, %v; want only D&D 5e", systems, err)
+	}
+
+	// Any of the system's names find its spells, including ones saved under
+	// an alias before it was registered
+	for _, name := range []string{"D&D 5e", "dnd5e", "5E"} {
+		spells, err := spellapi.GetAllSpell(ctx, store, url.Values{"system": []string{name}})
+		if err != nil || len(spells) != 2 {
+			t.Errorf("GetAllSpell(system=%s) = %d spells, %v; want 2", name, len(spells), err)
+		}
+	}
+	if spells, err := spellapi.GetAllSpell(ctx, store, url.Values{"system[ne]": []string{"dnd5e"}}); err != nil || len(spells) != 0 {
+		t.Errorf("GetAllSpell(system[ne]=dnd5e) = %d spells, %v; want 0", len(spells), err)
+	}
+	if _, err := spellapi.FindSpell(ctx, store, "fireball", url.Values{"system": []string{"D&D 5e"}}); err != nil {
+		t.Errorf("FindSpell(fireball, system=D&D 5e) err = %v; want nil", err)
+	}
+	if names, err := spellapi.AutocompleteSpells(ctx, store, "", "dnd5e", 10); err != nil || len(names) != 2 {
+		t.Errorf("AutocompleteSpells(system=dnd5e) = %v, %v; want 2 names", names, err)
+	}
+
+	replacement, err := spellapi.ParseSystem(ctx, []byte(`{"name":"D&D 5e","aliases":["5e"]}`))
+	if err != nil {
+		t.Fatalf("ParseSystem() err = %v; want nil", err)
+	}
+	if _, err := spellapi.ReplaceSystem(ctx, store, "dnd5e", replacement); err != nil {
+		t.Fatalf("ReplaceSystem() err = %v; want nil", err)
+	}
+	if _, err := spellapi.FindSystem(ctx, store, "dnd5e"); !errors.Is(err, spellapi.ErrSystemNotFound) {
+		t.Errorf("FindSystem(dnd5e) err = %v; want %v once the alias is removed", err, spellapi.ErrSystemNotFound)
+	}
+
+	if err := spellapi.DeleteSystem(ctx, store, "5E"); err != nil {
+		t.Fatalf("DeleteSystem() err = %v; want nil", err)
+	}
+	systemList, err := spellapi.GetSystems(ctx, store)
+	if err != nil || len(systemList) != 0 {
+		t.Errorf("GetSystems() = %v, %v; want none left", systemList, err)
+	}
+}
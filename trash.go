@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+)
+
+// TrashedSpell is a deleted spell waiting in the trash, ID is used to restore
+// it
+type TrashedSpell struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
+	DeletedBy string    `json:"deletedBy,omitempty"`
+	Spell     Spell     `json:"spell"`
+}
+
+type trashEntry struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Deleted struct {
+		At time.Time `bson:"at"`
+		By string    `bson:"by"`
+	} `bson:"deleted"`
+}
+
+// GetTrash lists deleted spells matching the query, most recently deleted
+// first. The query works the same as GetAllSpell.
+func GetTrash(ctx context.Context, db Store, query url.Values) ([]TrashedSpell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "GetTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("GetTrash.RawQuery", query.Encode()))
+
+	bsonQuery := bson.M{}
+
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetTrash.Error", err.Error()))
+		return nil, err
+	}
+
+	results, err := db.GetTrash(ctx, bsonQuery)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetTrash.Error", err.Error()))
+		return nil, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("GetTrash.ResultsCount", len(results)))
+
+	trashed, err := decodeTrash(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("GetTrash.Error", err.Error()))
+		return nil, err
+	}
+
+	return trashed, nil
+}
+
+// findTrashedSpell looks up a deleted spell by name the same way FindSpell
+// does for the rest, failing with ErrNotFound if there isn't one
+func findTrashedSpell(ctx context.Context, db Store, name string, query url.Values) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "FindTrashedSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("FindTrashedSpell.Spellname", name))
+
+	key := normalizeName(name)
+	bsonQuery := bson.M{
+		"$or": []bson.M{
+			{"name": bson.M{"$eq": key}},
+			{"aliasKeys": bson.M{"$eq": key}},
+		},
+	}
+
+	err := addQueryFilters(ctx, db, bsonQuery, query)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindTrashedSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	results, err := db.GetTrash(ctx, bsonQuery)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindTrashedSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	trashed, err := decodeTrash(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("FindTrashedSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	span.SetAttributes(attribute.Int("FindTrashedSpell.ResultsCount", len(trashed)))
+
+	if len(trashed) == 0 {
+		return Spell{}, ErrNotFound
+	} else if len(trashed) > 1 {
+		return Spell{}, ErrAmbiguousMatch
+	}
+
+	return trashed[0].Spell, nil
+}
+
+// RestoreSpell takes a spell back out of the trash. It fails with ErrConflict
+// if another spell has been given the same name and system since.
+func RestoreSpell(ctx context.Context, db Store, id string, user string) (Spell, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "RestoreSpell")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("RestoreSpell.Id", id))
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", err.Error()))
+		return Spell{}, newValidationError("id", "invalid id: %s", id)
+	}
+
+	results, err := db.GetTrash(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("query failed on DB: %w", err)
+	}
+
+	if len(results) == 0 {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", SpellNotFound))
+		return Spell{}, ErrNotFound
+	}
+
+	trashed, err := decodeTrash(results)
+	if err != nil {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", err.Error()))
+		return Spell{}, err
+	}
+
+	err = db.RestoreSpell(ctx, objectId)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", err.Error()))
+		return Spell{}, err
+	} else if err != nil {
+		span.SetAttributes(attribute.String("RestoreSpell.Error", err.Error()))
+		return Spell{}, fmt.Errorf("failed to restore spell in DB: %w", err)
+	}
+
+	err = recordRevision(ctx, db, RevisionRestored, trashed[0].Spell, user)
+	warnRevisionError(span, "RestoreSpell", err)
+
+	return trashed[0].Spell, nil
+}
+
+// PurgeTrash permanently deletes spells that have been in the trash for longer
+// than retention and returns how many were removed
+func PurgeTrash(ctx context.Context, db Store, retention time.Duration) (int64, error) {
+	tracer := otel.Tracer("Encantus")
+	ctx, span := tracer.Start(ctx, "PurgeTrash")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("PurgeTrash.Retention", retention.String()))
+
+	purged, err := db.PurgeTrash(ctx, time.Now().Add(-retention))
+	if err != nil {
+		span.SetAttributes(attribute.String("PurgeTrash.Error", err.Error()))
+		return 0, fmt.Errorf("failed to purge trash: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("PurgeTrash.Purged", purged))
+
+	return purged, nil
+}
+
+// runTrashPurge purges the trash every interval until the context is
+// cancelled
+func runTrashPurge(ctx context.Context, db Store, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := PurgeTrash(ctx, db, retention)
+		if err != nil {
+			log.Printf("trash purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d spells from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trashPurgeSettings reads how long deleted spells are kept and how often the
+// trash is checked. A retention of 0 keeps them forever.
+func trashPurgeSettings() (time.Duration, time.Duration, error) {
+	retention := defaultTrashRetention
+	if v := os.Getenv("SPELLAPI_TRASH_RETENTION"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid SPELLAPI_TRASH_RETENTION %q", v)
+		}
+		retention = parsed
+	}
+
+	interval := defaultTrashPurgeInterval
+	if v := os.Getenv("SPELLAPI_TRASH_PURGE_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("invalid SPELLAPI_TRASH_PURGE_INTERVAL %q", v)
+		}
+		interval = parsed
+	}
+
+	return retention, interval, nil
+}
+
+func decodeTrash(results []bson.M) ([]TrashedSpell, error) {
+	spells, err := decodeSpells(results)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]TrashedSpell, len(results))
+	for i, v := range results {
+		temp, err := bson.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshall data: %w", err)
+		}
+
+		var entry trashEntry
+		err = bson.Unmarshal(temp, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshall data: %w", err)
+		}
+
+		trashed[i] = TrashedSpell{
+			ID:        entry.ID.Hex(),
+			DeletedAt: entry.Deleted.At.UTC(),
+			DeletedBy: entry.Deleted.By,
+			Spell:     spells[i],
+		}
+	}
+
+	return trashed, nil
+}
@@ -0,0 +1,80 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	spellapi "github.com/chrislgardner/spellapi"
+	"github.com/chrislgardner/spellapi/db"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryDB()
+	addTestSpells(t, store,
+		`{"name":"fireball","description":"Big boom","metadata":{"system":"test1"}}`,
+		`{"name":"cure wounds","description":"Heals","metadata":{"system":"test1"}}`,
+	)
+
+	query := url.Values{"system": []string{"test1"}}
+	_, err := spellapi.DeleteSpell(ctx, store, "fireball", query, "", "tester", false)
+	if err != nil {
+		t.Fatalf("DeleteSpell() err = %v; want nil", err)
+	}
+
+	got, err := spellapi.FindSpell(ctx, store, "fireball", query)
+	if err != nil || got.Name != "" {
+		t.Fatalf("FindSpell() = %v, %v; want a deleted spell to be hidden", got, err)
+	}
+
+	trashed, err := spellapi.GetTrash(ctx, store, query)
+	if err != nil {
+		t.Fatalf("GetTrash() err = %v; want nil", err)
+	}
+	if len(trashed) != 1 || trashed[0].Spell.Name != "fireball" || trashed[0].DeletedBy != "tester" || trashed[0].DeletedAt.IsZero() {
+		t.Fatalf("GetTrash() returned %v; want the deleted fireball", trashed)
+	}
+
+	// A new spell with the same name blocks the restore until it's gone
+	addTestSpells(t, store, `{"name":"fireball","description":"New boom","metadata":{"system":"test1"}}`)
+	_, err = spellapi.RestoreSpell(ctx, store, trashed[0].ID, "tester")
+	if !errors.Is(err, spellapi.ErrConflict) {
+		t.Errorf("RestoreSpell() err = %v; want %v", err, spellapi.ErrConflict)
+	}
+
+	_, err = spellapi.DeleteSpell(ctx, store, "fireball", query, "", "tester", false)
+	if err != nil {
+		t.Fatalf("DeleteSpell() err = %v; want nil", err)
+	}
+
+	restored, err := spellapi.RestoreSpell(ctx, store, trashed[0].ID, "tester")
+	if err != nil {
+		t.Fatalf("RestoreSpell() err = %v; want nil", err)
+	}
+	if restored.Description != "Big boom" {
+		t.Errorf("RestoreSpell() returned %v; want the original fireball", restored)
+	}
+
+	_, err = spellapi.RestoreSpell(ctx, store, trashed[0].ID, "tester")
+	if !errors.Is(err, spellapi.ErrNotFound) {
+		t.Errorf("RestoreSpell() err = %v; want %v", err, spellapi.ErrNotFound)
+	}
+
+	_, err = spellapi.RestoreSpell(ctx, store, "not-an-id", "tester")
+	if !errors.Is(err, spellapi.ErrValidation) {
+		t.Errorf("RestoreSpell() err = %v; want %v", err, spellapi.ErrValidation)
+	}
+
+	purged, err := spellapi.PurgeTrash(ctx, store, time.Hour)
+	if err != nil || purged != 0 {
+		t.Errorf("PurgeTrash(1h) = %d, %v; want 0, nil", purged, err)
+	}
+
+	purged, err = spellapi.PurgeTrash(ctx, store, 0)
+	if err != nil || purged != 1 {
+		t.Errorf("PurgeTrash(0) = %d, %v; want 1, nil", purged, err)
+	}
+}